@@ -0,0 +1,104 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/awans/mark/feed"
+)
+
+// VerifyAnnouncement checks a signed announcement is from a feed we have,
+// and that the feed declared the pub it announces
+func (db *DB) VerifyAnnouncement(signed string) (*feed.Announcement, error) {
+	unverified, err := feed.OpenAnnouncement(signed)
+	if err != nil {
+		return nil, err
+	}
+	sf, err := db.GetFeed(unverified.From)
+	if err != nil || len(sf) == 0 {
+		return nil, errors.New("Unknown feed: " + unverified.From)
+	}
+	a, err := feed.VerifyAnnouncement(signed, sf)
+	if err != nil {
+		return nil, err
+	}
+	declared, err := db.e.DeclaredPub(a.From)
+	if err != nil {
+		return nil, err
+	}
+	if declared == "" || declared != a.Pub.URL {
+		return nil, errors.New("Feed " + a.From + " hasn't declared " + a.Pub.URL)
+	}
+	return a, nil
+}
+
+// announcedPub returns our record of the pub at url, adding it if it's new
+// Nothing else the announcer says about itself is taken, so it can't reset
+// its own health or backoff
+func (db *DB) announcedPub(url string) (*feed.Pub, error) {
+	p, err := db.e.GetPub(url)
+	if err != nil || p != nil {
+		return p, err
+	}
+	now := time.Now().Unix()
+	p = &feed.Pub{URL: url, LastChecked: now, LastUpdated: now}
+	return p, db.AddPub(p)
+}
+
+// ReceiveAnnouncement brings the db up to date with the heads in a verified announcement
+// Pushed ops are applied directly once they verify; otherwise only the feeds
+// we're behind on are fetched, from the pub that announced them
+func (db *DB) ReceiveAnnouncement(a *feed.Announcement) error {
+	p, err := db.announcedPub(a.Pub.URL)
+	if err != nil {
+		return err
+	}
+	// a sync run can change the pub while we fetch from it
+	before := *p
+	save := func() error {
+		return db.UpdatePub(p.URL, func(cur *feed.Pub) {
+			cur.Merge(before, *p)
+		})
+	}
+
+	want, err := db.Wants()
+	if err != nil {
+		return err
+	}
+	for _, h := range a.Heads {
+		if want != nil && !want(h.ID) {
+			continue
+		}
+		// a feed we've never seen doesn't decode, so it's just empty
+		local, _ := db.GetFeed(h.ID)
+		if len(local) >= h.Len {
+			continue
+		}
+		if sf, ok := a.Pushed(h, local); ok {
+			fmt.Printf("Announce: applying pushed ops for %s - %d\n", h.ID, h.Len)
+			err = db.PutFeed(sf)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		fmt.Printf("Announce: fetching feed %s - %d\n", h.ID, h.Len)
+		sf, err := db.syncer.Fetch(context.Background(), p, h.ID, local)
+		if err != nil {
+			// keep what the fetch showed of the pub's health
+			save()
+			return err
+		}
+		if sf == nil {
+			continue
+		}
+		err = db.PutFeed(sf)
+		if err != nil {
+			return err
+		}
+	}
+	return save()
+}
@@ -0,0 +1,49 @@
+package app
+
+// Block stops replicating a feed, drops it, and publishes the block
+func (db *DB) Block(id string) error {
+	return db.e.Block(id)
+}
+
+// Unblock lets a blocked feed be replicated again
+func (db *DB) Unblock(id string) error {
+	return db.e.Unblock(id)
+}
+
+// IsBlocked says whether the user blocks a feed
+func (db *DB) IsBlocked(id string) (bool, error) {
+	return db.e.IsBlocked(id)
+}
+
+// GetBlocked returns the feeds the user blocks
+func (db *DB) GetBlocked() ([]string, error) {
+	return db.e.GetBlocked()
+}
+
+// blockedSet returns the blocked feeds as a set
+func (db *DB) blockedSet() (map[string]bool, error) {
+	blocked, err := db.e.GetBlocked()
+	if err != nil {
+		return nil, err
+	}
+	set := make(map[string]bool)
+	for _, id := range blocked {
+		set[id] = true
+	}
+	return set, nil
+}
+
+// Mute hides a feed from the stream, but keeps replicating it
+func (db *DB) Mute(id string) error {
+	return db.e.Mute(id)
+}
+
+// Unmute shows a muted feed in the stream again
+func (db *DB) Unmute(id string) error {
+	return db.e.Unmute(id)
+}
+
+// GetMuted returns the muted feeds
+func (db *DB) GetMuted() ([]string, error) {
+	return db.e.GetMuted()
+}
@@ -0,0 +1,120 @@
+package app
+
+import (
+	"testing"
+)
+
+// streamFeeds returns which feeds have bookmarks in the user's stream
+func streamFeeds(t *testing.T, n *testNode) map[string]bool {
+	stream, err := n.GetStream(100, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	feeds := make(map[string]bool)
+	for _, b := range stream {
+		feeds[b.FeedID] = true
+	}
+	return feeds
+}
+
+func TestBlockAndUnblock(t *testing.T) {
+	me, doneMe := newTestNode(t)
+	defer doneMe()
+	a, doneA := newTestNode(t)
+	defer doneA()
+	if err := a.AddBookmark(&Bookmark{Title: "a's", URL: "https://example.com/a"}); err != nil {
+		t.Fatal(err)
+	}
+	me.follow(t, a)
+	me.give(t, a)
+	if !streamFeeds(t, me)[a.fp] {
+		t.Fatal("a isn't in the stream")
+	}
+
+	// with no self pub the announcement fails, but the block is written
+	me.Block(a.fp)
+	if blocked, err := me.IsBlocked(a.fp); err != nil || !blocked {
+		t.Errorf("blocked is %v, %v", blocked, err)
+	}
+	if streamFeeds(t, me)[a.fp] {
+		t.Error("a blocked feed is in the stream")
+	}
+	if sf, _ := me.GetFeed(a.fp); len(sf) != 0 {
+		t.Error("a blocked feed wasn't dropped")
+	}
+	want, err := me.Wants()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want(a.fp) {
+		t.Error("a blocked feed is still wanted")
+	}
+
+	me.Unblock(a.fp)
+	if err := me.Unblock(a.fp); err == nil {
+		t.Error("unblocking twice didn't fail")
+	}
+	want, err = me.Wants()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !want(a.fp) {
+		t.Error("an unblocked feed isn't wanted")
+	}
+	// the next sync brings it back
+	me.give(t, a)
+	if !streamFeeds(t, me)[a.fp] {
+		t.Error("an unblocked feed isn't in the stream")
+	}
+}
+
+func TestMuteIsLocal(t *testing.T) {
+	me, doneMe := newTestNode(t)
+	defer doneMe()
+	a, doneA := newTestNode(t)
+	defer doneA()
+	if err := a.AddBookmark(&Bookmark{Title: "a's", URL: "https://example.com/a"}); err != nil {
+		t.Fatal(err)
+	}
+	me.follow(t, a)
+	me.give(t, a)
+	before := len(me.feed(t))
+
+	if err := me.Mute(a.fp); err != nil {
+		t.Fatal(err)
+	}
+	if streamFeeds(t, me)[a.fp] {
+		t.Error("a muted feed is in the stream")
+	}
+	if stream, err := me.GetStream(100, 0, a.fp); err != nil || len(stream) != 1 {
+		t.Errorf("a muted feed's own stream: got %v, %v", stream, err)
+	}
+	want, err := me.Wants()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !want(a.fp) {
+		t.Error("a muted feed isn't replicated")
+	}
+
+	// nothing about the mute is signed into the user's feed
+	if after := len(me.feed(t)); after != before {
+		t.Errorf("muting added %d ops to the feed", after-before)
+	}
+	f, err := me.e.UserFeed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, op := range f.Ops {
+		if op.Op != "eav" && op.Op != "declare-key" {
+			t.Errorf("the feed has a %s op", op.Op)
+		}
+	}
+
+	if err := me.Unmute(a.fp); err != nil {
+		t.Fatal(err)
+	}
+	if !streamFeeds(t, me)[a.fp] {
+		t.Error("an unmuted feed isn't in the stream")
+	}
+}
@@ -0,0 +1,134 @@
+package app
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/awans/mark/feed"
+)
+
+// ImportStats summarizes what an import merged
+type ImportStats struct {
+	Feeds     int // feeds that were new or longer than ours
+	Unchanged int // feeds no longer than ours
+	Unwanted  int // feeds we don't replicate, blocked or too many hops away
+	Rejected  int // feeds that didn't verify
+	Pubs      int // pubs we didn't know about
+}
+
+func (s ImportStats) String() string {
+	return fmt.Sprintf("%d feeds merged, %d unchanged, %d unwanted, %d rejected, %d new pubs",
+		s.Feeds, s.Unchanged, s.Unwanted, s.Rejected, s.Pubs)
+}
+
+// ExportBundle bundles the given feeds, or every feed we serve if ids is empty,
+// along with the pubs we know
+func (db *DB) ExportBundle(ids []string) (*feed.Bundle, error) {
+	var feeds []feed.SignedFeed
+	if len(ids) == 0 {
+		all, err := db.GetFeeds()
+		if err != nil {
+			return nil, err
+		}
+		blocked, err := db.blockedSet()
+		if err != nil {
+			return nil, err
+		}
+		for _, sf := range all {
+			fp, err := sf.Fingerprint()
+			if err != nil {
+				return nil, err
+			}
+			if !blocked[fp] {
+				feeds = append(feeds, sf)
+			}
+		}
+	}
+	for _, id := range ids {
+		sf, err := db.GetFeed(id)
+		if err != nil || len(sf) == 0 {
+			return nil, fmt.Errorf("No such feed: %s", id)
+		}
+		feeds = append(feeds, sf)
+	}
+
+	pubs, err := db.GetPubs()
+	if err != nil {
+		return nil, err
+	}
+	return feed.NewBundle(feeds, pubs), nil
+}
+
+// ImportBundle merges a bundle's feeds and pubs, as if they'd been synced
+// Feeds are verified, and only taken if they're longer than ours, so they're
+// applied incrementally, or rebuilt from if they fork from our copy
+// Like a sync, only the feeds we replicate are taken; the follows in the feeds
+// taken can make more of them wanted, so the bundle is gone over until none are
+func (db *DB) ImportBundle(b *feed.Bundle) (ImportStats, error) {
+	var stats ImportStats
+	pending := make(map[string]feed.SignedFeed)
+	var order []string
+	for _, sf := range b.Feeds {
+		fp, err := sf.Fingerprint()
+		if err != nil || sf.Verify() != nil {
+			stats.Rejected++
+			continue
+		}
+		if _, ok := pending[fp]; !ok {
+			order = append(order, fp)
+		}
+		pending[fp] = sf
+	}
+
+	for taken := true; taken; {
+		taken = false
+		want, err := db.Wants()
+		if err != nil {
+			return stats, err
+		}
+		for _, fp := range order {
+			sf, ok := pending[fp]
+			if !ok || !want(fp) {
+				continue
+			}
+			delete(pending, fp)
+			// a feed we've never seen doesn't decode, so it's just empty
+			local, _ := db.GetFeed(fp)
+			if len(sf) <= len(local) {
+				stats.Unchanged++
+				continue
+			}
+			err = db.PutFeed(sf)
+			if err != nil {
+				return stats, err
+			}
+			stats.Feeds++
+			taken = true
+		}
+	}
+	stats.Unwanted = len(pending)
+
+	pubs, err := db.GetPubs()
+	if err != nil {
+		return stats, err
+	}
+	// our own pub is in there too
+	known := make(map[string]bool)
+	for _, p := range pubs {
+		known[string(p.URLHash())] = true
+	}
+	for _, p := range b.Pubs {
+		if known[string(p.URLHash())] {
+			continue
+		}
+		known[string(p.URLHash())] = true
+		p.LastChecked = time.Now().Unix()
+		p.LastUpdated = time.Now().Unix()
+		err = db.PutPub(&p)
+		if err != nil {
+			return stats, err
+		}
+		stats.Pubs++
+	}
+	return stats, nil
+}
@@ -0,0 +1,135 @@
+package app
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/awans/mark/feed"
+)
+
+// roundTrip writes a bundle and reads it back, as export and import do
+func roundTrip(t *testing.T, b *feed.Bundle) *feed.Bundle {
+	var buf bytes.Buffer
+	if err := b.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	read, err := feed.ReadBundle(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return read
+}
+
+func TestExportImport(t *testing.T) {
+	a, doneA := newTestNode(t)
+	defer doneA()
+	b, doneB := newTestNode(t)
+	defer doneB()
+
+	if err := a.AddBookmark(&Bookmark{Title: "a's", URL: "https://example.com/a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.AddPub(&feed.Pub{URL: "https://pub.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	b.follow(t, a)
+
+	bundle, err := a.ExportBundle(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := b.ImportBundle(roundTrip(t, bundle))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (ImportStats{Feeds: 1, Pubs: 1}); stats != want {
+		t.Errorf("got %v, want %v", stats, want)
+	}
+	stream, err := b.GetStream(10, 0, a.fp)
+	if err != nil || len(stream) != 1 || stream[0].Title != "a's" {
+		t.Errorf("got %v, %v", stream, err)
+	}
+
+	// importing again changes nothing
+	stats, err = b.ImportBundle(roundTrip(t, bundle))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (ImportStats{Unchanged: 1}); stats != want {
+		t.Errorf("again: got %v, want %v", stats, want)
+	}
+}
+
+func TestImportOnlyWantedFeeds(t *testing.T) {
+	// b follows a, who follows c; d is followed by no one
+	a, doneA := newTestNode(t)
+	defer doneA()
+	c, doneC := newTestNode(t)
+	defer doneC()
+	d, doneD := newTestNode(t)
+	defer doneD()
+	a.follow(t, c)
+	// c's feed comes before a's, so it's only wanted once a's is taken
+	bundle := feed.NewBundle([]feed.SignedFeed{d.feed(t), c.feed(t), a.feed(t)}, nil)
+
+	cases := []struct {
+		name  string
+		hops  int
+		block *testNode
+		want  ImportStats
+	}{
+		{"followed", 1, nil, ImportStats{Feeds: 1, Unwanted: 2}},
+		{"followed and theirs", 2, nil, ImportStats{Feeds: 2, Unwanted: 1}},
+		{"every feed", -1, nil, ImportStats{Feeds: 3}},
+		{"blocked", 2, c, ImportStats{Feeds: 1, Unwanted: 2}},
+		{"blocked, every feed", -1, d, ImportStats{Feeds: 2, Unwanted: 1}},
+	}
+	for _, tc := range cases {
+		b, done := newTestNode(t)
+		b.follow(t, a)
+		b.SetHops(tc.hops)
+		if tc.block != nil {
+			if err := b.Block(tc.block.fp); err != nil {
+				t.Fatal(err)
+			}
+		}
+		stats, err := b.ImportBundle(roundTrip(t, bundle))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, stats, tc.want)
+		}
+		done()
+	}
+}
+
+func TestImportRejectsTamperedFeeds(t *testing.T) {
+	a, doneA := newTestNode(t)
+	defer doneA()
+	b, doneB := newTestNode(t)
+	defer doneB()
+	if err := a.AddBookmark(&Bookmark{Title: "a's", URL: "https://example.com/a"}); err != nil {
+		t.Fatal(err)
+	}
+	b.follow(t, a)
+
+	sf := a.feed(t)
+	// swap the last op's payload for the first's, keeping the last op's signature
+	last := strings.Split(sf[len(sf)-1], ".")
+	last[1] = strings.Split(sf[0], ".")[1]
+	tampered := append(feed.SignedFeed(nil), sf...)
+	tampered[len(tampered)-1] = strings.Join(last, ".")
+
+	stats, err := b.ImportBundle(roundTrip(t, feed.NewBundle([]feed.SignedFeed{tampered}, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (ImportStats{Rejected: 1}); stats != want {
+		t.Errorf("got %v, want %v", stats, want)
+	}
+	if got, _ := b.GetFeed(a.fp); len(got) != 0 {
+		t.Errorf("took %d ops of a tampered feed", len(got))
+	}
+}
@@ -1,6 +1,7 @@
 package app
 
 import (
+	"context"
 	"time"
 
 	"github.com/awans/mark/entities"
@@ -9,12 +10,25 @@ import (
 
 // DB is the application-level DB interface
 type DB struct {
-	e *entities.DB
+	e      *entities.DB
+	hops   int
+	syncer *feed.Syncer // fetches feeds for sync and announcements
 }
 
 // NewDB makes a new app db from an entity db
 func NewDB(e *entities.DB) *DB {
-	return &DB{e: e}
+	return &DB{e: e, hops: DefaultHops, syncer: feed.NewSyncer(feed.DefaultSyncWorkers, feed.DefaultPubTimeout)}
+}
+
+// Configure declares the app's indexes on an entity db
+// Call it before the indexes are rebuilt
+func Configure(e *entities.DB) {
+	e.DeclareIndex("Bookmark", "CreatedAt", entities.IndexAVE)
+	e.DeclareIndex("Bookmark", "Title", entities.IndexFullText)
+	e.DeclareIndex("Bookmark", "URL", entities.IndexUnique|entities.IndexFullText)
+	e.DeclareIndex("Bookmark", "Note", entities.IndexFullText)
+	e.DeclareIndex("Profile", "Name", entities.IndexAVE)
+	e.DeclareIndex("Follow", "Target", entities.IndexAVE)
 }
 
 // Close closes the underlying db
@@ -24,26 +38,67 @@ func (db *DB) Close() {
 
 // GetStream returns a user's stream
 func (db *DB) GetStream(count, offset int, feedID string) ([]Bookmark, error) {
+	return db.GetStreamContext(context.Background(), count, offset, feedID)
+}
+
+// GetStreamContext is GetStream, but gives up once ctx is done
+func (db *DB) GetStreamContext(ctx context.Context, count, offset int, feedID string) ([]Bookmark, error) {
 	var bookmarks []Bookmark
 	q := db.e.NewQuery("Bookmark").Order("-CreatedAt").Limit(count).Offset(offset)
 	if feedID != "" {
 		q = q.Filter("FeedID =", feedID)
+	} else {
+		muted, err := db.e.GetMuted()
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range muted {
+			q = q.Filter("FeedID !=", m)
+		}
+	}
+	err := q.GetAllContext(ctx, &bookmarks)
+	if err != nil {
+		return nil, err
 	}
-	q.GetAll(&bookmarks)
 	return bookmarks, nil
 }
 
+// Search returns bookmarks matching every word of text, best match first
+func (db *DB) Search(text string, count, offset int) ([]Bookmark, error) {
+	return db.SearchContext(context.Background(), text, count, offset)
+}
+
+// SearchContext is Search, but gives up once ctx is done
+func (db *DB) SearchContext(ctx context.Context, text string, count, offset int) ([]Bookmark, error) {
+	var bookmarks []Bookmark
+	q := db.e.NewQuery("Bookmark").Search("Title,URL,Note", text).Limit(count).Offset(offset)
+	err := q.GetAllContext(ctx, &bookmarks)
+	return bookmarks, err
+}
+
 // AddBookmark inserts a bookmark into the db
+// Bookmarking a URL again updates the existing bookmark
 func (db *DB) AddBookmark(b *Bookmark) error {
+	return db.AddBookmarkContext(context.Background(), b)
+}
+
+// AddBookmarkContext is AddBookmark, but gives up if ctx is done before it's written
+func (db *DB) AddBookmarkContext(ctx context.Context, b *Bookmark) error {
 	b.CreatedAt = int(time.Now().Unix())
-	id, err := db.e.Add(b)
+	b.URL = NormalizeURL(b.URL)
+	id, err := db.e.UpsertContext(ctx, "Bookmark", "URL", b.URL, b)
 	b.ID = id
 	return err
 }
 
 // RemoveBookmark removes a bookmark from the db
 func (db *DB) RemoveBookmark(id string) error {
-	err := db.e.Remove(id)
+	return db.RemoveBookmarkContext(context.Background(), id)
+}
+
+// RemoveBookmarkContext is RemoveBookmark, but gives up if ctx is done before it's written
+func (db *DB) RemoveBookmarkContext(ctx context.Context, id string) error {
+	err := db.e.RemoveContext(ctx, id)
 	return err
 }
 
@@ -84,11 +139,16 @@ func (db *DB) GetProfile(feedID string) (*Profile, error) {
 
 // SetProfile sets the current user's Profile
 func (db *DB) SetProfile(p *Profile) error {
+	return db.SetProfileContext(context.Background(), p)
+}
+
+// SetProfileContext is SetProfile, but gives up if ctx is done before it's written
+func (db *DB) SetProfileContext(ctx context.Context, p *Profile) error {
 	old, err := db.GetUserProfile()
 	if err != nil {
 		return err
 	}
-	return db.e.Put(old.ID, p)
+	return db.e.PutContext(ctx, old.ID, p)
 }
 
 // GetPubs returns all pubs
@@ -96,11 +156,31 @@ func (db *DB) GetPubs() ([]feed.Pub, error) {
 	return db.e.GetPubs()
 }
 
+// Info describes what this node speaks to other nodes
+func (db *DB) Info() feed.Info {
+	return feed.LocalInfo(db.e.Ops())
+}
+
+// Version changes whenever a feed or pub does
+func (db *DB) Version() uint64 {
+	return db.e.Version()
+}
+
 // PutPub adds a pub
 func (db *DB) PutPub(p *feed.Pub) error {
 	return db.e.PutPub(p)
 }
 
+// AddPub adds a pub unless it's already known
+func (db *DB) AddPub(p *feed.Pub) error {
+	return db.e.AddPub(p)
+}
+
+// UpdatePub changes the latest record of the pub at url with fn
+func (db *DB) UpdatePub(url string, fn func(*feed.Pub)) error {
+	return db.e.UpdatePub(url, fn)
+}
+
 // GetSelf returns the pub that represents this node
 func (db *DB) GetSelf() (*feed.Pub, error) {
 	return db.e.GetSelf()
@@ -125,3 +205,8 @@ func (db *DB) GetFeed(id string) (feed.SignedFeed, error) {
 func (db *DB) PutFeed(f feed.SignedFeed) error {
 	return db.e.PutFeed(f)
 }
+
+// PutFeedContext sets a feed in the db, applying its ops until ctx is done
+func (db *DB) PutFeedContext(ctx context.Context, f feed.SignedFeed) error {
+	return db.e.PutFeedContext(ctx, f)
+}
@@ -0,0 +1,93 @@
+package app
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/awans/mark/entities"
+	"github.com/awans/mark/feed"
+)
+
+// testKeyBits keeps key generation quick; test keys only sign test feeds
+const testKeyBits = 1024
+
+// testNode is a user with their own feed and store
+type testNode struct {
+	*DB
+	fp string
+}
+
+// newTestNode makes a user the way mark init does, and a func that removes its store
+func newTestNode(t *testing.T) (*testNode, func()) {
+	dir, err := ioutil.TempDir("", "mark-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := entities.CreateBackendStore(entities.BoltBackend, dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	cleanup := func() {
+		store.Close()
+		os.RemoveAll(dir)
+	}
+	fail := func(err error) {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, testKeyBits)
+	if err != nil {
+		fail(err)
+	}
+	f, err := feed.New(key)
+	if err != nil {
+		fail(err)
+	}
+	fp, err := f.Fingerprint()
+	if err != nil {
+		fail(err)
+	}
+	e := entities.NewDB(store, fp, key)
+	Configure(e)
+	if _, err = e.PutUserFeed(f); err != nil {
+		fail(err)
+	}
+	if err = e.MigrateKeys(); err != nil {
+		fail(err)
+	}
+	if err = e.OpenIndexes(); err != nil {
+		fail(err)
+	}
+	return &testNode{DB: NewDB(e), fp: fp}, cleanup
+}
+
+// feed is the node's own signed feed
+func (n *testNode) feed(t *testing.T) feed.SignedFeed {
+	sf, err := n.GetFeed(n.fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sf
+}
+
+// give puts the other nodes' feeds in n, as if n had synced them
+func (n *testNode) give(t *testing.T, others ...*testNode) {
+	for _, o := range others {
+		if err := n.PutFeed(o.feed(t)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func (n *testNode) follow(t *testing.T, others ...*testNode) {
+	for _, o := range others {
+		if _, err := n.Follow(o.fp); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
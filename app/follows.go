@@ -0,0 +1,124 @@
+package app
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotFollowing is returned when unfollowing a feed the user doesn't follow
+var ErrNotFollowing = errors.New("Not following that feed")
+
+// DefaultHops replicates the feeds the user follows, and the feeds they follow
+const DefaultHops = 2
+
+// SetHops sets how many follows away from the user a feed can be and still be replicated
+// 0 is just the user's feed, and -1 replicates every feed
+func (db *DB) SetHops(hops int) {
+	db.hops = hops
+}
+
+// Follow makes the user follow a feed
+// Following a feed again is a no-op
+func (db *DB) Follow(target string) (*Follow, error) {
+	f := Follow{CreatedAt: int(time.Now().Unix()), Target: target}
+	id, err := db.e.Upsert("Follow", "Target", target, &f)
+	f.ID = id
+	return &f, err
+}
+
+// Unfollow makes the user stop following a feed
+func (db *DB) Unfollow(target string) error {
+	follows, err := db.GetFollows("")
+	if err != nil {
+		return err
+	}
+	for _, f := range follows {
+		if f.Target == target {
+			return db.e.Remove(f.ID)
+		}
+	}
+	return ErrNotFollowing
+}
+
+// GetFollows returns the follows published in a feed, or the user's if feedID is empty
+func (db *DB) GetFollows(feedID string) ([]Follow, error) {
+	if feedID == "" {
+		self, err := db.selfID()
+		if err != nil {
+			return nil, err
+		}
+		feedID = self
+	}
+	var follows []Follow
+	err := db.e.NewQuery("Follow").Filter("FeedID =", feedID).GetAll(&follows)
+	return follows, err
+}
+
+// WithinHops returns the feeds at most hops follows from the user, with their distance
+func (db *DB) WithinHops(hops int) (map[string]int, error) {
+	self, err := db.selfID()
+	if err != nil {
+		return nil, err
+	}
+	var all []Follow
+	err = db.e.NewQuery("Follow").GetAll(&all)
+	if err != nil {
+		return nil, err
+	}
+	blocked, err := db.blockedSet()
+	if err != nil {
+		return nil, err
+	}
+	graph := make(map[string][]string)
+	for _, f := range all {
+		if !blocked[f.Target] {
+			graph[f.FeedID] = append(graph[f.FeedID], f.Target)
+		}
+	}
+
+	dist := map[string]int{self: 0}
+	frontier := []string{self}
+	for hop := 1; hop <= hops && len(frontier) > 0; hop++ {
+		var next []string
+		for _, id := range frontier {
+			for _, target := range graph[id] {
+				if _, ok := dist[target]; !ok {
+					dist[target] = hop
+					next = append(next, target)
+				}
+			}
+		}
+		frontier = next
+	}
+	return dist, nil
+}
+
+// Wants returns which feeds the db replicates
+// Blocked feeds are never replicated, however many hops away
+func (db *DB) Wants() (func(string) bool, error) {
+	blocked, err := db.blockedSet()
+	if err != nil {
+		return nil, err
+	}
+	if db.hops < 0 {
+		return func(id string) bool {
+			return !blocked[id]
+		}, nil
+	}
+	in, err := db.WithinHops(db.hops)
+	if err != nil {
+		return nil, err
+	}
+	return func(id string) bool {
+		_, ok := in[id]
+		return ok && !blocked[id]
+	}, nil
+}
+
+func (db *DB) selfID() (string, error) {
+	feed, err := db.e.UserFeed()
+	if err != nil {
+		return "", err
+	}
+	return feed.Fingerprint()
+}
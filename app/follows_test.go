@@ -0,0 +1,79 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWithinHopsAndWants(t *testing.T) {
+	// me follows a, who follows b, who follows c and back to me
+	me, doneMe := newTestNode(t)
+	defer doneMe()
+	a, doneA := newTestNode(t)
+	defer doneA()
+	b, doneB := newTestNode(t)
+	defer doneB()
+	c, doneC := newTestNode(t)
+	defer doneC()
+	stranger, doneS := newTestNode(t)
+	defer doneS()
+	me.follow(t, a)
+	a.follow(t, b)
+	b.follow(t, c, me)
+	me.give(t, a, b, c, stranger)
+
+	cases := []struct {
+		name string
+		hops int
+		want map[string]int
+	}{
+		{"just me", 0, map[string]int{me.fp: 0}},
+		{"followed", 1, map[string]int{me.fp: 0, a.fp: 1}},
+		{"followed and theirs", 2, map[string]int{me.fp: 0, a.fp: 1, b.fp: 2}},
+		// b following me back doesn't make me 4 hops away
+		{"around the cycle", 5, map[string]int{me.fp: 0, a.fp: 1, b.fp: 2, c.fp: 3}},
+	}
+	for _, tc := range cases {
+		got, err := me.WithinHops(tc.hops)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+
+		me.SetHops(tc.hops)
+		want, err := me.Wants()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, n := range []*testNode{me, a, b, c, stranger} {
+			_, in := tc.want[n.fp]
+			if want(n.fp) != in {
+				t.Errorf("%s: wants %s is %v, want %v", tc.name, n.fp, want(n.fp), in)
+			}
+		}
+	}
+
+	me.SetHops(-1)
+	want, err := me.Wants()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !want(stranger.fp) {
+		t.Error("-1 hops doesn't want every feed")
+	}
+
+	// with no self pub the announcement fails, but the unfollow is written
+	me.Unfollow(a.fp)
+	if err := me.Unfollow(a.fp); err != ErrNotFollowing {
+		t.Errorf("unfollowing again: got %v, want %v", err, ErrNotFollowing)
+	}
+	got, err := me.WithinHops(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]int{me.fp: 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after unfollowing: got %v, want %v", got, want)
+	}
+}
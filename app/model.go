@@ -16,3 +16,12 @@ type Profile struct {
 	FeedID string `json:"feed_id"`
 	Name   string `json:"name"`
 }
+
+// Follow says a user wants another user's feed replicated
+// Unfollowing removes it
+type Follow struct {
+	ID        string `json:"id"`
+	FeedID    string `json:"feed_id"`
+	CreatedAt int    `json:"created_at"`
+	Target    string `json:"target"`
+}
@@ -2,6 +2,7 @@ package app
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"time"
 
@@ -9,15 +10,44 @@ import (
 	"github.com/awans/mark/feed"
 )
 
+// SyncOptions configure the sync loop
+type SyncOptions struct {
+	Interval   time.Duration
+	Workers    int           // pubs talked to at once
+	PubTimeout time.Duration // for each request to a pub
+}
+
 // Sync starts a goroutine that runs sync every durationSpec
 func Sync(durationSpec string, db *entities.DB) error {
 	duration, err := time.ParseDuration(durationSpec)
 	if err != nil {
 		return err
 	}
-	ticker := time.NewTicker(duration)
-	go func(db *entities.DB) {
-		for t := range ticker.C {
+	SyncWithOptions(context.Background(), NewDB(db), SyncOptions{
+		Interval:   duration,
+		Workers:    feed.DefaultSyncWorkers,
+		PubTimeout: feed.DefaultPubTimeout,
+	})
+	return nil
+}
+
+// SyncWithOptions starts a goroutine that syncs every o.Interval until ctx is done
+// Call it before serving, since announcements fetch with the same Syncer
+// A tick that comes while the last sync is still running is skipped
+// Only the feeds db replicates are fetched
+func SyncWithOptions(ctx context.Context, db *DB, o SyncOptions) {
+	db.syncer = feed.NewSyncer(o.Workers, o.PubTimeout)
+	syncer := db.syncer
+	ticker := time.NewTicker(o.Interval)
+	go func(db *DB) {
+		for {
+			var t time.Time
+			select {
+			case <-ctx.Done():
+				ticker.Stop()
+				return
+			case t = <-ticker.C:
+			}
 			fmt.Println("Syncing at", t)
 			feeds, err := db.GetFeeds()
 			if err != nil {
@@ -45,32 +75,43 @@ func Sync(durationSpec string, db *entities.DB) error {
 					other = append(other, p)
 				}
 			}
+			// the run changes other, and announcements can change the pubs meanwhile
+			before := append([]feed.Pub(nil), other...)
 
-			newPubs, newFeeds, err := feed.Sync(other, feeds)
+			want, err := db.Wants()
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			newPubs, newFeeds, stats, err := syncer.Run(ctx, other, feeds, want)
 			if err != nil {
 				fmt.Println(err)
 				continue
 			}
 			for _, f := range newFeeds {
-				err = db.PutFeed(f)
+				err = db.PutFeedContext(ctx, f)
 				if err != nil {
 					fmt.Println(err)
+					stats.Failures++
 				}
 			}
+			fmt.Println("Synced:", stats)
 			// update old pubs too to track failures and backoff
-			for _, p := range other {
-				err = db.PutPub(&p)
+			for i := range other {
+				after := other[i]
+				err = db.UpdatePub(after.URL, func(p *feed.Pub) {
+					p.Merge(before[i], after)
+				})
 				if err != nil {
 					fmt.Println(err)
 				}
 			}
 			for _, p := range newPubs {
-				err = db.PutPub(&p)
+				err = db.AddPub(&p)
 				if err != nil {
 					fmt.Println(err)
 				}
 			}
 		}
 	}(db)
-	return nil
 }
@@ -0,0 +1,26 @@
+package app
+
+import (
+	"net/url"
+	"strings"
+)
+
+// NormalizeURL puts a URL in the form bookmarks are deduped by
+// Scheme and host are lowercased, default ports dropped and an empty path becomes "/"
+// Anything that doesn't parse as an absolute URL is only trimmed
+func NormalizeURL(s string) string {
+	s = strings.TrimSpace(s)
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return s
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if (u.Scheme == "http" && u.Port() == "80") || (u.Scheme == "https" && u.Port() == "443") {
+		u.Host = strings.TrimSuffix(u.Host, ":"+u.Port())
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	return u.String()
+}
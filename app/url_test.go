@@ -0,0 +1,29 @@
+package app
+
+import "testing"
+
+func TestNormalizeURL(t *testing.T) {
+	cases := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{"already normal", "https://example.com/a", "https://example.com/a"},
+		{"scheme and host case", "HTTPS://Example.COM/a", "https://example.com/a"},
+		{"path case is kept", "https://example.com/About", "https://example.com/About"},
+		{"default http port", "http://example.com:80/a", "http://example.com/a"},
+		{"default https port", "https://example.com:443/a", "https://example.com/a"},
+		{"other ports", "https://example.com:80/a", "https://example.com:80/a"},
+		{"empty path", "https://example.com", "https://example.com/"},
+		{"query and fragment", "https://example.com/a?b=c#d", "https://example.com/a?b=c#d"},
+		{"whitespace", "  https://example.com/a\n", "https://example.com/a"},
+		{"relative", " /a/b ", "/a/b"},
+		{"not a url", "example.com", "example.com"},
+	}
+
+	for _, c := range cases {
+		if got := NormalizeURL(c.url); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
@@ -1,12 +1,14 @@
 package main
 
 import (
+	"context"
 	"crypto/rsa"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"time"
 
 	"github.com/awans/mark/app"
@@ -22,18 +24,36 @@ const bootstrapURL = "https://marko.awans.org"
 const usage = `mark
 
 Usage:
-  mark init [-d <dir>]
-  mark serve [-d <dir>] [-p <port>]
+  mark init [-d <dir>] [--store <backend>]
+  mark serve [-d <dir>] [-p <port>] [--sync-workers <n>] [--pub-timeout <duration>] [--hops <n>]
   mark dump [-d <dir>]
   mark rebuild [-d <dir>]
+  mark fsck [-d <dir>] [--repair]
+  mark feeds list [-d <dir>]
+  mark feeds drop <feed-id> [-d <dir>]
+  mark follow <feed-id> [-d <dir>]
+  mark block <feed-id> [-d <dir>]
+  mark unblock <feed-id> [-d <dir>]
+  mark blocked [-d <dir>]
+  mark mute <feed-id> [-d <dir>]
+  mark unmute <feed-id> [-d <dir>]
+  mark muted [-d <dir>]
+  mark export-bundle <file> [<feed>...] [-d <dir>]
+  mark import-bundle <file> [-d <dir>]
+  mark migrate-store <backend> [-d <dir>]
 
 Options:
 	-d <dir>, --data-dir <dir>  Specify data directory [default: /var/opt/mark]
 	-p <port>, --port <port>		Specify port [default: 8080]
+	--repair                    Rebuild the indexes from feeds if fsck finds problems
+	--store <backend>           Storage backend, kv or bolt [default: kv]
+	--sync-workers <n>          Pubs to sync with at once [default: 4]
+	--pub-timeout <duration>    Give up on a pub request after this long [default: 10s]
+	--hops <n>                  Replicate feeds this many follows away, -1 for all [default: 2]
 
 `
 
-func initFeed(markDir string) error {
+func initFeed(markDir string, backend string) error {
 	err := os.RemoveAll(markDir)
 	if err != nil {
 		return err
@@ -43,7 +63,7 @@ func initFeed(markDir string) error {
 		return err
 	}
 
-	store, err := entities.CreateStore(markDir)
+	store, err := entities.CreateBackendStore(backend, markDir)
 	if err != nil {
 		return err
 	}
@@ -65,15 +85,18 @@ func initFeed(markDir string) error {
 	}
 	db := entities.NewDB(store, fp, key)
 	_, err = db.PutUserFeed(feed)
-	return err
+	if err != nil {
+		return err
+	}
+	return db.MigrateKeys()
 }
 
-func openDbAndKeys(markDir string) (*rsa.PrivateKey, *entities.DB, error) {
+func openDbAndKeys(markDir string, openIndexes bool) (*rsa.PrivateKey, *entities.DB, error) {
 	key, err := feed.OpenKeys(markDir)
 	if err != nil {
 		return nil, nil, err
 	}
-	store, err := entities.OpenStore(markDir)
+	store, err := entities.OpenBackendStore(entities.DetectBackend(markDir), markDir)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -84,7 +107,17 @@ func openDbAndKeys(markDir string) (*rsa.PrivateKey, *entities.DB, error) {
 	}
 
 	db := entities.NewDB(store, fp, key)
-	db.RebuildIndexes()
+	app.Configure(db)
+	if openIndexes {
+		err = db.MigrateKeys()
+		if err != nil {
+			return nil, nil, err
+		}
+		err = db.OpenIndexes()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
 
 	return key, db, nil
 }
@@ -100,6 +133,183 @@ func rebuild(db *entities.DB) {
 	}
 }
 
+func fsck(db *entities.DB, repair bool) error {
+	problems, err := db.Check()
+	if err != nil {
+		return err
+	}
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) == 0 {
+		fmt.Println("No problems found")
+		return nil
+	}
+	if !repair {
+		return fmt.Errorf("%d problems found", len(problems))
+	}
+
+	// an interrupted rebuild isn't checkpointed, so the next start finishes it
+	fmt.Println("Rebuilding indexes from feeds")
+	err = db.RebuildIndexesContext(interruptContext())
+	if err != nil {
+		return err
+	}
+	problems, err = db.Check()
+	if err != nil {
+		return err
+	}
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d problems remain after repair", len(problems))
+	}
+	fmt.Println("Repaired")
+	return nil
+}
+
+func listFeeds(db *entities.DB) error {
+	feeds, err := db.GetFeeds()
+	if err != nil {
+		return err
+	}
+	for _, f := range feeds {
+		fp, err := f.Fingerprint()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\t%d\n", fp, len(f))
+	}
+	return nil
+}
+
+// exportBundle writes the given feeds, or all of them, and the known pubs to file
+func exportBundle(db *entities.DB, file string, ids []string) error {
+	b, err := app.NewDB(db).ExportBundle(ids)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	err = b.Write(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Exported %d feeds and %d pubs to %s\n", len(b.Feeds), len(b.Pubs), file)
+	return nil
+}
+
+// importBundle merges a bundle written by exportBundle
+func importBundle(db *entities.DB, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	b, err := feed.ReadBundle(f)
+	if err != nil {
+		return err
+	}
+	stats, err := app.NewDB(db).ImportBundle(b)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Imported:", stats)
+	return nil
+}
+
+func follow(db *entities.DB, target string) error {
+	// following announces the new op
+	feed.Initialize(HTTPGetter{})
+	f, err := app.NewDB(db).Follow(target)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Following %s\n", f.Target)
+	return nil
+}
+
+func block(db *entities.DB, target string) error {
+	// blocking announces the new op
+	feed.Initialize(HTTPGetter{})
+	err := db.Block(target)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Blocked %s\n", target)
+	return nil
+}
+
+func unblock(db *entities.DB, target string) error {
+	feed.Initialize(HTTPGetter{})
+	err := db.Unblock(target)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Unblocked %s\n", target)
+	return nil
+}
+
+func printFeeds(ids []string, err error) error {
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		fmt.Println(id)
+	}
+	return nil
+}
+
+// migrateStore copies the store in markDir to a new backend,
+// and moves the old one aside so the new one gets picked up
+func migrateStore(markDir string, backend string) error {
+	from := entities.DetectBackend(markDir)
+	if from == backend {
+		return fmt.Errorf("Store is already %s", backend)
+	}
+	fromFile, err := entities.BackendFile(from, markDir)
+	if err != nil {
+		return err
+	}
+	src, err := entities.OpenBackendStore(from, markDir)
+	if err != nil {
+		return err
+	}
+	dst, err := entities.CreateBackendStore(backend, markDir)
+	if err != nil {
+		src.Close()
+		return err
+	}
+
+	n, err := entities.CopyStore(dst, src)
+	src.Close()
+	dst.Close()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Copied %d keys from %s to %s\n", n, from, backend)
+	return os.Rename(fromFile, fromFile+".bak")
+}
+
+func syncOptions(args map[string]interface{}) (app.SyncOptions, error) {
+	so := app.SyncOptions{Interval: 10 * time.Second}
+	workers, err := strconv.Atoi(args["--sync-workers"].(string))
+	if err != nil {
+		return so, err
+	}
+	so.Workers = workers
+	so.PubTimeout, err = time.ParseDuration(args["--pub-timeout"].(string))
+	return so, err
+}
+
 // HTTPGetter implements Getter with the net/http package
 type HTTPGetter struct{}
 
@@ -109,20 +319,51 @@ func (g HTTPGetter) Get(url string) (*http.Response, error) {
 	return res, err
 }
 
-func serve(db *entities.DB, key *rsa.PrivateKey, port string) error {
-	bootstrap := feed.Pub{URL: bootstrapURL, LastUpdated: time.Now().Unix(), LastChecked: time.Now().Unix()}
-	db.PutPub(&bootstrap)
+// GetContext implements ContextGetter
+func (g HTTPGetter) GetContext(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return http.DefaultClient.Do(req.WithContext(ctx))
+}
+
+// GetHeaders implements HeaderGetter
+func (g HTTPGetter) GetHeaders(ctx context.Context, url string, h http.Header) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = h
+	return http.DefaultClient.Do(req.WithContext(ctx))
+}
 
-	// Catch ctrl-c and gracefully exit
+// interruptContext is done once the process is interrupted
+func interruptContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, os.Kill)
 	go func() {
 		<-c
+		cancel()
+	}()
+	return ctx
+}
+
+func serve(db *entities.DB, key *rsa.PrivateKey, port string, so app.SyncOptions, hops int) error {
+	bootstrap := feed.Pub{URL: bootstrapURL, LastUpdated: time.Now().Unix(), LastChecked: time.Now().Unix()}
+	db.PutPub(&bootstrap)
+
+	// Catch ctrl-c, stop syncing, and gracefully exit
+	ctx := interruptContext()
+	go func() {
+		<-ctx.Done()
 		db.Close()
 		os.Exit(0)
 	}()
 
 	appDB := app.NewDB(db)
+	appDB.SetHops(hops)
 
 	// The server bootstraps a sandstorm sessionBus
 	// so it has to be called *before* the app.Sync starts..
@@ -135,31 +376,47 @@ func serve(db *entities.DB, key *rsa.PrivateKey, port string) error {
 		feed.Initialize(HTTPGetter{})
 	}
 
-	app.Sync("10s", db)
+	app.SyncWithOptions(ctx, appDB, so)
 
 	fmt.Printf("Now serving on :%s\n", port)
 	return http.ListenAndServe(":"+port, s)
 }
 
 func main() {
-	args, _ := docopt.Parse(usage, nil, true, "Mark 0", false)
+	args, _ := docopt.Parse(usage, nil, true, "Mark "+feed.SoftwareVersion, false)
 	dir := args["--data-dir"].(string)
 
 	if args["init"].(bool) {
-		err := initFeed(dir)
+		err := initFeed(dir, args["--store"].(string))
 		if err != nil {
 			log.Fatal(err)
 		}
 		os.Exit(0)
+	} else if args["migrate-store"].(bool) {
+		err := migrateStore(dir, args["<backend>"].(string))
+		if err != nil {
+			log.Fatal(err)
+		}
 	} else {
-		key, db, err := openDbAndKeys(dir) // maybe wrap this in a Session
+		// fsck has to see the indexes as they were left on disk
+		key, db, err := openDbAndKeys(dir, !args["fsck"].(bool)) // maybe wrap this in a Session
 		if err != nil {
 			log.Fatal(err)
 		}
 		defer db.Close()
 		if args["serve"].(bool) {
 			port := args["--port"].(string)
-			err = serve(db, key, port)
+			so, err := syncOptions(args)
+			if err != nil {
+				db.Close()
+				log.Fatal(err)
+			}
+			hops, err := strconv.Atoi(args["--hops"].(string))
+			if err != nil {
+				db.Close()
+				log.Fatal(err)
+			}
+			err = serve(db, key, port, so, hops)
 			if err != nil {
 				log.Fatal(err)
 			}
@@ -167,6 +424,78 @@ func main() {
 			dump(db)
 		} else if args["rebuild"].(bool) {
 			rebuild(db)
+		} else if args["feeds"].(bool) && args["list"].(bool) {
+			err = listFeeds(db)
+			if err != nil {
+				db.Close()
+				log.Fatal(err)
+			}
+		} else if args["feeds"].(bool) && args["drop"].(bool) {
+			err = db.DropFeed(args["<feed-id>"].(string))
+			if err != nil {
+				db.Close()
+				log.Fatal(err)
+			}
+		} else if args["follow"].(bool) {
+			err = follow(db, args["<feed-id>"].(string))
+			if err != nil {
+				db.Close()
+				log.Fatal(err)
+			}
+		} else if args["export-bundle"].(bool) {
+			err = exportBundle(db, args["<file>"].(string), args["<feed>"].([]string))
+			if err != nil {
+				db.Close()
+				log.Fatal(err)
+			}
+		} else if args["import-bundle"].(bool) {
+			err = importBundle(db, args["<file>"].(string))
+			if err != nil {
+				db.Close()
+				log.Fatal(err)
+			}
+		} else if args["block"].(bool) {
+			err = block(db, args["<feed-id>"].(string))
+			if err != nil {
+				db.Close()
+				log.Fatal(err)
+			}
+		} else if args["unblock"].(bool) {
+			err = unblock(db, args["<feed-id>"].(string))
+			if err != nil {
+				db.Close()
+				log.Fatal(err)
+			}
+		} else if args["blocked"].(bool) {
+			err = printFeeds(db.GetBlocked())
+			if err != nil {
+				db.Close()
+				log.Fatal(err)
+			}
+		} else if args["mute"].(bool) {
+			err = db.Mute(args["<feed-id>"].(string))
+			if err != nil {
+				db.Close()
+				log.Fatal(err)
+			}
+		} else if args["unmute"].(bool) {
+			err = db.Unmute(args["<feed-id>"].(string))
+			if err != nil {
+				db.Close()
+				log.Fatal(err)
+			}
+		} else if args["muted"].(bool) {
+			err = printFeeds(db.GetMuted())
+			if err != nil {
+				db.Close()
+				log.Fatal(err)
+			}
+		} else if args["fsck"].(bool) {
+			err = fsck(db, args["--repair"].(bool))
+			if err != nil {
+				db.Close()
+				log.Fatal(err)
+			}
 		}
 	}
 }
@@ -0,0 +1,113 @@
+package entities
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// aveIterator walks a slice of the AVE index, so the store does the sorting
+// It only sees entities with a value for the attribute; see newOrderedIterator
+type aveIterator struct {
+	kind string
+	attr string
+	db   *DB
+	iter Iterator
+	err  error // from opening the range, returned by Next
+}
+
+// newAVEIterator iterates over the entities of kind that have a value for attr,
+// in order of value, or only those with val if it's not nil
+func newAVEIterator(db *DB, kind string, attr string, val interface{}, reverse bool) queryIterator {
+	i := aveIterator{db: db, kind: kind, attr: attr}
+	i.err = i.init(val, reverse)
+	return &i
+}
+
+// newOrderedIterator iterates over every entity of kind in order of attr
+// Entities without the attribute sort as if it were empty, like orderIterator
+// sorts them, so they come first, or last in reverse; putting them first means
+// scanning the kind for them before the index is read
+func newOrderedIterator(db *DB, kind string, attr string, reverse bool) queryIterator {
+	missing := &missingIterator{db: db, attr: attr, inner: newKindIterator(db, kind)}
+	ave := newAVEIterator(db, kind, attr, nil, reverse)
+	if reverse {
+		return &concatIterator{iters: []queryIterator{ave, missing}}
+	}
+	return &concatIterator{iters: []queryIterator{missing, ave}}
+}
+
+func (i *aveIterator) init(val interface{}, reverse bool) error {
+	prefix := NewKey("ave", i.attr)
+	if val != nil {
+		prefix = NewKey("ave", i.attr, fmt.Sprintf("%v", val))
+	}
+	start := prefix.Prefix()
+	iter, err := i.db.store.Range(start, prefixEnd(start), reverse)
+	i.iter = iter
+	return err
+}
+
+func (i *aveIterator) Next() (string, error) {
+	if i.err != nil {
+		return "", i.err
+	}
+	for {
+		_, v, err := i.iter.Next()
+		if err != nil {
+			return "", err
+		}
+		eid := string(v)
+		if i.isKind(eid) {
+			return eid, nil
+		}
+	}
+}
+
+// isKind checks the entity's kind for system attributes, which every kind shares
+func (i *aveIterator) isKind(eid string) bool {
+	if !strings.HasPrefix(i.attr, "db/") {
+		return true
+	}
+	v, err := i.db.store.Get(NewKey("eav", eid, "db/Kind").ToBytes())
+	return err == nil && string(v) == i.kind
+}
+
+// missingIterator passes on the entities that have no value for attr
+type missingIterator struct {
+	db    *DB
+	attr  string
+	inner queryIterator
+}
+
+func (i *missingIterator) Next() (string, error) {
+	for {
+		eid, err := i.inner.Next()
+		if err != nil {
+			return "", err
+		}
+		v, err := i.db.store.Get(NewKey("eav", eid, i.attr).ToBytes())
+		if err != nil {
+			return "", err
+		}
+		if v == nil {
+			return eid, nil
+		}
+	}
+}
+
+// concatIterator runs through each of its iterators in turn
+type concatIterator struct {
+	iters []queryIterator
+}
+
+func (i *concatIterator) Next() (string, error) {
+	for len(i.iters) > 0 {
+		eid, err := i.iters[0].Next()
+		if err != io.EOF {
+			return eid, err
+		}
+		i.iters = i.iters[1:]
+	}
+	return "", io.EOF
+}
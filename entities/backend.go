@@ -0,0 +1,81 @@
+package entities
+
+import (
+	"errors"
+	"os"
+	"path"
+)
+
+// Store backends
+const (
+	KvBackend   = "kv"
+	BoltBackend = "bolt"
+)
+
+// Files each backend keeps its data in, within the data directory
+const (
+	kvFilename   = "db"
+	boltFilename = "db.bolt"
+)
+
+// BackendFile returns the path of the file a backend's store lives in
+func BackendFile(backend string, dirname string) (string, error) {
+	switch backend {
+	case KvBackend:
+		return path.Join(dirname, kvFilename), nil
+	case BoltBackend:
+		return path.Join(dirname, boltFilename), nil
+	}
+	return "", errors.New("Unknown store backend: " + backend)
+}
+
+// DetectBackend returns the backend of the store in dirname
+// A bolt file wins, since it's only there if someone asked for it
+func DetectBackend(dirname string) string {
+	if _, err := os.Stat(path.Join(dirname, boltFilename)); err == nil {
+		return BoltBackend
+	}
+	return KvBackend
+}
+
+// CreateBackendStore makes a new store with the given backend
+func CreateBackendStore(backend string, dirname string) (Store, error) {
+	switch backend {
+	case KvBackend:
+		return CreateStore(dirname)
+	case BoltBackend:
+		return CreateBoltStore(dirname)
+	}
+	return nil, errors.New("Unknown store backend: " + backend)
+}
+
+// OpenBackendStore opens an existing store with the given backend
+func OpenBackendStore(backend string, dirname string) (Store, error) {
+	switch backend {
+	case KvBackend:
+		return OpenStore(dirname)
+	case BoltBackend:
+		return OpenBoltStore(dirname)
+	}
+	return nil, errors.New("Unknown store backend: " + backend)
+}
+
+// CopyStore copies every key in src to dst in batches, returning how many it copied
+func CopyStore(dst Store, src Store) (int, error) {
+	i, err := src.Range(nil, nil, false)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	err = dst.Update(func(dst Store) error {
+		for k, v, err := i.Next(); err == nil; k, v, err = i.Next() {
+			err = dst.Set(k, v)
+			if err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
@@ -0,0 +1,200 @@
+package entities
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// testStores makes an empty store of each kind, and a func that removes them
+func testStores(t *testing.T) (map[string]Store, func()) {
+	dir, err := ioutil.TempDir("", "mark-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stores := map[string]Store{"mem": newMemStore()}
+	for _, backend := range []string{KvBackend, BoltBackend} {
+		sub := dir + "/" + backend
+		err = os.Mkdir(sub, 0777)
+		if err == nil {
+			stores[backend], err = CreateBackendStore(backend, sub)
+		}
+		if err != nil {
+			os.RemoveAll(dir)
+			t.Fatal(err)
+		}
+	}
+	return stores, func() {
+		for _, s := range stores {
+			s.Close()
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func drainKeys(t *testing.T, i Iterator) []string {
+	var keys []string
+	k, _, err := i.Next()
+	for ; err == nil; k, _, err = i.Next() {
+		keys = append(keys, string(k))
+	}
+	if err != io.EOF {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestCopyStore(t *testing.T) {
+	stores, cleanup := testStores(t)
+	defer cleanup()
+
+	src := stores["mem"]
+	want := make(map[string]string)
+	for n := 0; n < 2*boltBatchSize+1; n++ {
+		k := NewKey("eav", fmt.Sprintf("f:%d", n), "Note/Title").ToBytes()
+		v := fmt.Sprintf("note %d", n)
+		src.Set(k, []byte(v))
+		want[string(k)] = v
+	}
+
+	for _, backend := range []string{KvBackend, BoltBackend} {
+		dst := stores[backend]
+		n, err := CopyStore(dst, src)
+		if err != nil {
+			t.Fatalf("%s: %v", backend, err)
+		}
+		if n != len(want) {
+			t.Errorf("%s: copied %d keys, want %d", backend, n, len(want))
+		}
+		got := make(map[string]string)
+		for k := range want {
+			v, err := dst.Get([]byte(k))
+			if err != nil {
+				t.Fatalf("%s: %v", backend, err)
+			}
+			got[k] = string(v)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: the copy doesn't match", backend)
+		}
+	}
+}
+
+func TestBoltPrefixAcrossBatches(t *testing.T) {
+	stores, cleanup := testStores(t)
+	defer cleanup()
+	s := stores[BoltBackend]
+
+	var want []string
+	for n := 0; n < 2*boltBatchSize+1; n++ {
+		k := NewKey("ave", "Note/Stars", fmt.Sprintf("%04d", n)).ToBytes()
+		s.Set(k, []byte("f:1"))
+		want = append(want, string(k))
+	}
+	s.Set(NewKey("ave", "Note/Title", "a").ToBytes(), []byte("f:1"))
+	s.Set(NewKey("ave", "Note").ToBytes(), []byte("f:1"))
+
+	i, err := s.Prefix(NewKey("ave", "Note/Stars").Prefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// writing mid-scan is fine, since each batch is read in its own transaction
+	i.Next()
+	s.Set(NewKey("ave", "Note/Stars", "0000").ToBytes(), []byte("f:2"))
+	if got := drainKeys(t, i); !reflect.DeepEqual(got, want[1:]) {
+		t.Errorf("got %d keys, want the %d after the first", len(got), len(want)-1)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	stores, cleanup := testStores(t)
+	defer cleanup()
+
+	// enough for bolt to commit partway, and to read across batches
+	var want []string
+	for n := 0; n < boltUpdateSize+boltBatchSize+1; n++ {
+		want = append(want, string(NewKey("eav", fmt.Sprintf("f:%04d", n), "Note/Title").ToBytes()))
+	}
+	stop := errors.New("stop")
+
+	for name, s := range stores {
+		err := s.Update(func(tx Store) error {
+			for _, k := range want {
+				tx.Set([]byte(k), []byte("a"))
+			}
+			// the batch reads its own writes, even while changing what it reads
+			i, err := tx.Prefix(NewKey("eav").Prefix())
+			if err != nil {
+				return err
+			}
+			var got []string
+			for k, _, err := i.Next(); err == nil; k, _, err = i.Next() {
+				got = append(got, string(k))
+				tx.Set(k, []byte("b"))
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: read %d keys in the batch, want %d", name, len(got), len(want))
+			}
+			return stop
+		})
+		if err != stop {
+			t.Errorf("%s: got %v, want %v", name, err, stop)
+		}
+
+		// an error doesn't undo the batch
+		for _, k := range want {
+			v, err := s.Get([]byte(k))
+			if err != nil || string(v) != "b" {
+				t.Errorf("%s: %s is %q, %v", name, k, v, err)
+				break
+			}
+		}
+	}
+}
+
+func TestRebuildOnEachBackend(t *testing.T) {
+	stores, cleanup := testStores(t)
+	defer cleanup()
+
+	tf := newTestFeed(t)
+	tf.append(t, noteOp("1", "a")).append(t, noteOp("2", "b"))
+	for name, s := range stores {
+		db := NewDB(s, "me", nil)
+		if err := db.PutFeed(tf.signed(t, db)); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		s.Set(staleKey, []byte("x"))
+		if err := db.RebuildIndexes(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !rebuilt(t, db) {
+			t.Errorf("%s: wasn't rebuilt", name)
+		}
+		if titles := noteTitles(t, db); len(titles) != 2 {
+			t.Errorf("%s: got %v", name, titles)
+		}
+	}
+}
+
+func TestDetectBackend(t *testing.T) {
+	for _, backend := range []string{KvBackend, BoltBackend} {
+		dir, err := ioutil.TempDir("", "mark-store")
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := CreateBackendStore(backend, dir)
+		if err != nil {
+			os.RemoveAll(dir)
+			t.Fatal(err)
+		}
+		s.Close()
+		if got := DetectBackend(dir); got != backend {
+			t.Errorf("got %s, want %s", got, backend)
+		}
+		os.RemoveAll(dir)
+	}
+}
@@ -0,0 +1,146 @@
+package entities
+
+import (
+	"errors"
+
+	"github.com/awans/mark/feed"
+)
+
+// ErrNotBlocked is returned when unblocking a feed the user doesn't block
+var ErrNotBlocked = errors.New("Not blocking that feed")
+
+// blockedKey records that blocker's feed blocks target
+// blocked/blocker/target
+func blockedKey(blocker string, target string) []byte {
+	return NewKey("blocked", blocker, target).ToBytes()
+}
+
+func (db *DB) applyBlock(b *feed.Block, fp string) {
+	if b.Blocked {
+		db.store.Set(blockedKey(fp, b.Feed), []byte("1"))
+	} else {
+		db.store.Delete(blockedKey(fp, b.Feed))
+	}
+}
+
+// Block publishes that the user blocks a feed, and drops it if we have it
+// Blocked feeds are never fetched or served
+func (db *DB) Block(id string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if id == db.fp {
+		return errors.New("Can't block your own feed")
+	}
+	err := db.appendOp(*feed.DeclareBlock(id, true))
+	if err != nil {
+		return err
+	}
+	v, err := db.store.Get(NewKey("feed", id).ToBytes())
+	if err != nil || v == nil {
+		return err
+	}
+	return db.dropFeed(id)
+}
+
+// Unblock publishes that the user no longer blocks a feed
+// Sync fetches it again if it's wanted
+func (db *DB) Unblock(id string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	v, err := db.store.Get(blockedKey(db.fp, id))
+	if err != nil {
+		return err
+	}
+	if v == nil {
+		return ErrNotBlocked
+	}
+	return db.appendOp(*feed.DeclareBlock(id, false))
+}
+
+// IsBlocked says whether the user blocks a feed
+func (db *DB) IsBlocked(id string) (bool, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	v, err := db.store.Get(blockedKey(db.fp, id))
+	return v != nil, err
+}
+
+// GetBlocked returns the feeds the user blocks
+func (db *DB) GetBlocked() ([]string, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.listKeys(NewKey("blocked", db.fp).Prefix())
+}
+
+// Mute hides a feed from the user's stream, but keeps replicating it
+// Mutes are local to this node and aren't published
+func (db *DB) Mute(id string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.clearCaches()
+	return db.store.Set(NewKey("mute", id).ToBytes(), []byte("1"))
+}
+
+// Unmute shows a muted feed again
+func (db *DB) Unmute(id string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.clearCaches()
+	return db.store.Delete(NewKey("mute", id).ToBytes())
+}
+
+// GetMuted returns the muted feeds
+func (db *DB) GetMuted() ([]string, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.listKeys(NewKey("mute").Prefix())
+}
+
+// listKeys returns the last component of each key under prefix
+func (db *DB) listKeys(prefix []byte) ([]string, error) {
+	i, err := db.store.Prefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for k, _, err := i.Next(); err == nil; k, _, err = i.Next() {
+		components := ParseKey(k).Components()
+		ids = append(ids, components[len(components)-1])
+	}
+	return ids, nil
+}
+
+// appendOp signs an op onto the user's feed and announces it
+func (db *DB) appendOp(op feed.Op) error {
+	f, err := db.userFeed()
+	if err != nil {
+		return err
+	}
+	f.Append(op, db.key)
+	sf, err := db.putUserFeed(f)
+	if err != nil {
+		return err
+	}
+	// like put, a node with nowhere to announce to still has the op
+	db.announce(sf)
+	return nil
+}
+
+// deletePrefix deletes every key under prefix
+func (db *DB) deletePrefix(prefix []byte) error {
+	i, err := db.store.Prefix(prefix)
+	if err != nil {
+		return err
+	}
+	var drop [][]byte
+	for k, _, err := i.Next(); err == nil; k, _, err = i.Next() {
+		drop = append(drop, k)
+	}
+	for _, k := range drop {
+		db.store.Delete(k)
+	}
+	return nil
+}
@@ -0,0 +1,305 @@
+package entities
+
+import (
+	"bytes"
+	"io"
+	"path"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltBucket holds every key; the key paths already namespace them
+var boltBucket = []byte("mark")
+
+// boltBatchSize is how many items an iterator reads per transaction
+const boltBatchSize = 256
+
+// BoltStore is an implementation of Store based on bbolt
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// CreateBoltStore makes a db file for a BoltStore
+func CreateBoltStore(dirname string) (Store, error) {
+	return OpenBoltStore(dirname)
+}
+
+// OpenBoltStore opens a BoltStore, creating it if it doesn't exist
+func OpenBoltStore(dirname string) (Store, error) {
+	filename := path.Join(dirname, boltFilename)
+	db, err := bolt.Open(filename, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return BoltStore{db: db}, nil
+}
+
+// Close closes a BoltStore and releases the file
+func (b BoltStore) Close() error {
+	return b.db.Close()
+}
+
+// Get reads a value from a BoltStore
+// Like KvStore, a missing key is a nil value rather than an error
+func (b BoltStore) Get(key []byte) ([]byte, error) {
+	var val []byte
+	err := b.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltBucket).Get(key)
+		if v != nil {
+			// bolt's values are only valid for the transaction
+			val = append([]byte{}, v...)
+		}
+		return nil
+	})
+	return val, err
+}
+
+// Set sets a value in a BoltStore
+func (b BoltStore) Set(key []byte, val []byte) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put(key, val)
+	})
+}
+
+// Delete removes a value
+func (b BoltStore) Delete(key []byte) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Delete(key)
+	})
+}
+
+// Prefix implements Store
+func (b BoltStore) Prefix(key []byte) (Iterator, error) {
+	return b.Range(key, prefixEnd(key), false)
+}
+
+// Range implements Store
+func (b BoltStore) Range(start []byte, end []byte, reverse bool) (Iterator, error) {
+	return &boltIterator{view: b.db.View, start: start, end: end, reverse: reverse}, nil
+}
+
+// Update implements Store
+func (b BoltStore) Update(fn func(Store) error) error {
+	t := &boltTxStore{db: b.db}
+	defer t.rollback()
+	ferr := fn(t)
+	err := t.commit()
+	if err != nil {
+		return err
+	}
+	return ferr
+}
+
+// boltUpdateSize is how many writes an Update commits at a time
+// bolt only splits nodes when it commits, so each write to a huge transaction
+// is slower than the last
+const boltUpdateSize = 1000
+
+// boltTxStore is a BoltStore within an Update,
+// which writes through one read-write transaction until it's committed
+type boltTxStore struct {
+	db     *bolt.DB
+	tx     *bolt.Tx
+	writes int
+}
+
+func (t *boltTxStore) begin() (*bolt.Tx, error) {
+	if t.tx == nil {
+		tx, err := t.db.Begin(true)
+		if err != nil {
+			return nil, err
+		}
+		t.tx = tx
+	}
+	return t.tx, nil
+}
+
+func (t *boltTxStore) commit() error {
+	if t.tx == nil {
+		return nil
+	}
+	err := t.tx.Commit()
+	t.tx, t.writes = nil, 0
+	return err
+}
+
+// rollback gives up the transaction if fn panicked before it was committed
+func (t *boltTxStore) rollback() {
+	if t.tx != nil {
+		t.tx.Rollback()
+	}
+}
+
+func (t *boltTxStore) write(fn func(*bolt.Bucket) error) error {
+	tx, err := t.begin()
+	if err != nil {
+		return err
+	}
+	err = fn(tx.Bucket(boltBucket))
+	if err != nil {
+		return err
+	}
+	t.writes++
+	if t.writes >= boltUpdateSize {
+		return t.commit()
+	}
+	return nil
+}
+
+// Close implements Store
+// The transaction belongs to Update, which commits it once fn returns
+func (t *boltTxStore) Close() error {
+	return nil
+}
+
+// Get implements Store
+func (t *boltTxStore) Get(key []byte) ([]byte, error) {
+	tx, err := t.begin()
+	if err != nil {
+		return nil, err
+	}
+	v := tx.Bucket(boltBucket).Get(key)
+	if v == nil {
+		return nil, nil
+	}
+	return append([]byte{}, v...), nil
+}
+
+// Set implements Store
+// bolt holds on to keys and values until the transaction commits,
+// so they're copied in case the caller reuses them
+func (t *boltTxStore) Set(key []byte, val []byte) error {
+	return t.write(func(b *bolt.Bucket) error {
+		return b.Put(append([]byte{}, key...), append([]byte{}, val...))
+	})
+}
+
+// Delete implements Store
+func (t *boltTxStore) Delete(key []byte) error {
+	return t.write(func(b *bolt.Bucket) error {
+		return b.Delete(key)
+	})
+}
+
+// Prefix implements Store
+func (t *boltTxStore) Prefix(key []byte) (Iterator, error) {
+	return t.Range(key, prefixEnd(key), false)
+}
+
+// Range implements Store
+// Each batch seeks a fresh cursor, so writes in between don't upset it
+func (t *boltTxStore) Range(start []byte, end []byte, reverse bool) (Iterator, error) {
+	view := func(fn func(*bolt.Tx) error) error {
+		tx, err := t.begin()
+		if err != nil {
+			return err
+		}
+		return fn(tx)
+	}
+	return &boltIterator{view: view, start: start, end: end, reverse: reverse}, nil
+}
+
+// Update implements Store
+// The writes are already being batched
+func (t *boltTxStore) Update(fn func(Store) error) error {
+	return fn(t)
+}
+
+// boltIterator reads a batch at a time, each in its own call to view,
+// so callers can write to the store while they iterate
+type boltIterator struct {
+	// view runs a function in a transaction that can read the store
+	view       func(func(*bolt.Tx) error) error
+	start, end []byte
+	reverse    bool
+
+	keys, vals [][]byte
+	// last is the last key handed out, so the next batch can pick up after it
+	last []byte
+	done bool
+}
+
+// Next implements Iterator
+func (i *boltIterator) Next() ([]byte, []byte, error) {
+	if len(i.keys) == 0 {
+		if i.done {
+			return nil, nil, io.EOF
+		}
+		err := i.fill()
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(i.keys) == 0 {
+			return nil, nil, io.EOF
+		}
+	}
+	k, v := i.keys[0], i.vals[0]
+	i.keys, i.vals = i.keys[1:], i.vals[1:]
+	i.last = k
+	return k, v, nil
+}
+
+func (i *boltIterator) fill() error {
+	return i.view(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltBucket).Cursor()
+		k, v := i.first(c)
+		for ; k != nil && len(i.keys) < boltBatchSize; k, v = i.step(c) {
+			if !inRange(k, i.start, i.end) {
+				i.done = true
+				return nil
+			}
+			i.keys = append(i.keys, append([]byte{}, k...))
+			i.vals = append(i.vals, append([]byte{}, v...))
+		}
+		if k == nil {
+			i.done = true
+		}
+		return nil
+	})
+}
+
+// first positions the cursor on the next key to return
+func (i *boltIterator) first(c *bolt.Cursor) ([]byte, []byte) {
+	if !i.reverse {
+		if i.last == nil {
+			if i.start == nil {
+				return c.First()
+			}
+			return c.Seek(i.start)
+		}
+		k, v := c.Seek(i.last)
+		if k != nil && bytes.Equal(k, i.last) {
+			return c.Next()
+		}
+		return k, v
+	}
+
+	bound := i.last
+	if bound == nil {
+		bound = i.end
+	}
+	if bound == nil {
+		return c.Last()
+	}
+	// Seek finds the first key >= bound; we want the one before it
+	k, _ := c.Seek(bound)
+	if k == nil {
+		return c.Last()
+	}
+	return c.Prev()
+}
+
+func (i *boltIterator) step(c *bolt.Cursor) ([]byte, []byte) {
+	if i.reverse {
+		return c.Prev()
+	}
+	return c.Next()
+}
@@ -0,0 +1,135 @@
+package entities
+
+import (
+	"container/list"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Cache sizes
+const (
+	entityCacheSize = 1024
+	queryCacheSize  = 128
+	// maxCachedResults keeps big result sets out of the query cache
+	maxCachedResults = 100
+)
+
+// lru is a fixed size least-recently-used cache
+// A nil *lru caches nothing
+type lru struct {
+	mu    sync.Mutex
+	size  int
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type lruEntry struct {
+	key string
+	val interface{}
+}
+
+func newLRU(size int) *lru {
+	return &lru{size: size, ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (c *lru) get(key string) (interface{}, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	return e.Value.(*lruEntry).val, true
+}
+
+func (c *lru) add(key string, val interface{}) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.ll.MoveToFront(e)
+		e.Value.(*lruEntry).val = val
+		return
+	}
+	c.items[key] = c.ll.PushFront(&lruEntry{key: key, val: val})
+	if c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry).key)
+	}
+}
+
+func (c *lru) remove(key string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.ll.Remove(e)
+		delete(c.items, key)
+	}
+}
+
+// removeIf drops every entry f matches
+func (c *lru) removeIf(f func(val interface{}) bool) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, e := range c.items {
+		if f(e.Value.(*lruEntry).val) {
+			c.ll.Remove(e)
+			delete(c.items, key)
+		}
+	}
+}
+
+func (c *lru) clear() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+}
+
+// cachedEntity is a hydrated entity
+type cachedEntity struct {
+	val reflect.Value
+}
+
+// cachedQuery is a query's result ids, and the attributes that can change them
+type cachedQuery struct {
+	kind string
+	deps map[string]bool
+	eids []string
+}
+
+// invalidate drops everything a datom could have changed
+func (db *DB) invalidate(d Datom) {
+	db.entityCache.remove(d.FeedID + ":" + d.EntityID)
+	db.queryCache.removeIf(func(val interface{}) bool {
+		cq := val.(*cachedQuery)
+		if d.Attribute == "db/Kind" {
+			// entities coming and going only matter to queries over their kind
+			return cq.kind == fmt.Sprintf("%v", d.Value)
+		}
+		return cq.deps[d.Attribute]
+	})
+}
+
+// clearCaches drops everything, eg when the indexes are rebuilt
+func (db *DB) clearCaches() {
+	db.entityCache.clear()
+	db.queryCache.clear()
+}
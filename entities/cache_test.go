@@ -0,0 +1,133 @@
+package entities
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newLRU(2)
+	c.add("a", 1)
+	c.add("b", 2)
+	c.get("a")
+	c.add("c", 3)
+
+	cases := []struct {
+		key  string
+		want interface{}
+		ok   bool
+	}{
+		{"a", 1, true},
+		{"b", nil, false},
+		{"c", 3, true},
+	}
+	for _, tc := range cases {
+		got, ok := c.get(tc.key)
+		if ok != tc.ok || got != tc.want {
+			t.Errorf("%s: got %v, %v, want %v, %v", tc.key, got, ok, tc.want, tc.ok)
+		}
+	}
+
+	var nilCache *lru
+	nilCache.add("a", 1)
+	if _, ok := nilCache.get("a"); ok {
+		t.Error("a nil lru cached something")
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	cases := []struct {
+		name     string
+		d        Datom
+		entities []string
+		queries  []string
+	}{
+		{
+			name:     "attribute of an entity",
+			d:        Datom{FeedID: "f", EntityID: "1", Attribute: "Note/Title", Value: "z", Added: true},
+			entities: []string{"f:2"},
+			queries:  []string{"byStars", "profiles"},
+		},
+		{
+			name:     "attribute nothing depends on",
+			d:        Datom{FeedID: "f", EntityID: "2", Attribute: "Note/First", Value: "z", Added: true},
+			entities: []string{"f:1"},
+			queries:  []string{"byStars", "byTitle", "profiles"},
+		},
+		{
+			name:     "new entity of a kind",
+			d:        Datom{FeedID: "f", EntityID: "3", Attribute: "db/Kind", Value: "Note", Added: true},
+			entities: []string{"f:1", "f:2"},
+			queries:  []string{"profiles"},
+		},
+		{
+			name:     "new entity of another kind",
+			d:        Datom{FeedID: "f", EntityID: "3", Attribute: "db/Kind", Value: "Profile", Added: true},
+			entities: []string{"f:1", "f:2"},
+			queries:  []string{"byStars", "byTitle"},
+		},
+	}
+
+	for _, c := range cases {
+		db := newTestDB()
+		applyEntity(db, "f:1", "Note", map[string]interface{}{"Note/Title": "a", "Note/Stars": 1})
+		applyEntity(db, "f:2", "Note", map[string]interface{}{"Note/Title": "b", "Note/Stars": 2})
+		queries := map[string]*Query{
+			"byTitle":  db.NewQuery("Note").Order("Title"),
+			"byStars":  db.NewQuery("Note").Filter("Stars =", "1"),
+			"profiles": db.NewQuery("Profile"),
+		}
+
+		var n Note
+		for _, id := range []string{"f:1", "f:2"} {
+			if err := db.Get(id, &n); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, q := range queries {
+			var notes []Note
+			if err := q.GetAll(&notes); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		db.invalidate(c.d)
+
+		var entities, cached []string
+		for _, id := range []string{"f:1", "f:2"} {
+			if _, ok := db.entityCache.get(id); ok {
+				entities = append(entities, id)
+			}
+		}
+		for _, name := range []string{"byStars", "byTitle", "profiles"} {
+			if _, ok := db.queryCache.get(queries[name].cacheKey()); ok {
+				cached = append(cached, name)
+			}
+		}
+		if !reflect.DeepEqual(entities, c.entities) {
+			t.Errorf("%s: cached entities %v, want %v", c.name, entities, c.entities)
+		}
+		if !reflect.DeepEqual(cached, c.queries) {
+			t.Errorf("%s: cached queries %v, want %v", c.name, cached, c.queries)
+		}
+	}
+}
+
+func TestCachedQuerySeesNewEntities(t *testing.T) {
+	db := newTestDB()
+	applyEntity(db, "f:1", "Note", map[string]interface{}{"Note/Title": "a"})
+
+	var notes []Note
+	if err := db.NewQuery("Note").GetAll(&notes); err != nil {
+		t.Fatal(err)
+	}
+	applyEntity(db, "f:2", "Note", map[string]interface{}{"Note/Title": "b"})
+
+	notes = nil
+	if err := db.NewQuery("Note").GetAll(&notes); err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 2 {
+		t.Errorf("got %d notes, want 2", len(notes))
+	}
+}
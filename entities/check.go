@@ -0,0 +1,245 @@
+package entities
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/awans/mark/feed"
+)
+
+var indexes = []string{"eav", "aev", "ave", "vae"}
+
+// Problem is an inconsistency found by Check
+type Problem struct {
+	Key    string
+	Reason string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s", p.Key, p.Reason)
+}
+
+// Check verifies each stored feed, then checks that the indexes agree
+// with each other and with what replaying the feeds would produce.
+// It doesn't modify anything; RebuildIndexes repairs the indexes.
+func (db *DB) Check() ([]Problem, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var problems []Problem
+
+	// replay every feed into memory so we know what the indexes should be
+	expected := &DB{store: newMemStore(), c: db.c, migrations: db.migrations, attrIndexes: db.attrIndexes}
+	fi, err := db.store.Prefix(NewKey("feed").Prefix())
+	if err != nil {
+		return nil, err
+	}
+	for k, v, err := fi.Next(); err == nil; k, v, err = fi.Next() {
+		key := string(k)
+		var sf feed.SignedFeed
+		err = json.Unmarshal(v, &sf)
+		if err != nil {
+			problems = append(problems, Problem{Key: key, Reason: err.Error()})
+			continue
+		}
+		err = sf.Verify()
+		if err != nil {
+			problems = append(problems, Problem{Key: key, Reason: err.Error()})
+		}
+		fp, err := sf.Fingerprint()
+		if err != nil {
+			problems = append(problems, Problem{Key: key, Reason: err.Error()})
+			continue
+		}
+		if !bytes.Equal(k, NewKey("feed", fp).ToBytes()) {
+			problems = append(problems, Problem{Key: key, Reason: "stored under the wrong id, expected " + fp})
+		}
+		f, err := db.c.Decode(sf)
+		if err != nil {
+			problems = append(problems, Problem{Key: key, Reason: err.Error()})
+			continue
+		}
+		expected.loadFeed(f)
+	}
+
+	ps, err := db.checkAgainst(expected.store)
+	if err != nil {
+		return nil, err
+	}
+	problems = append(problems, ps...)
+
+	ps, err = db.checkSymmetry()
+	if err != nil {
+		return nil, err
+	}
+	problems = append(problems, ps...)
+
+	ps, err = db.checkOrphans()
+	if err != nil {
+		return nil, err
+	}
+	problems = append(problems, ps...)
+	return problems, nil
+}
+
+// checkAgainst compares every index entry with the ones in expected
+func (db *DB) checkAgainst(expected Store) ([]Problem, error) {
+	var problems []Problem
+	for _, index := range indexes {
+		prefix := NewKey(index).Prefix()
+		i, err := db.store.Prefix(prefix)
+		if err != nil {
+			return nil, err
+		}
+		for k, v, err := i.Next(); err == nil; k, v, err = i.Next() {
+			ev, err := expected.Get(k)
+			if err != nil {
+				return nil, err
+			}
+			if ev == nil {
+				problems = append(problems, Problem{Key: string(k), Reason: "not produced by any feed"})
+			} else if !bytes.Equal(v, ev) {
+				problems = append(problems, Problem{Key: string(k), Reason: fmt.Sprintf("value is %q, feeds say %q", v, ev)})
+			}
+		}
+
+		i, err = expected.Prefix(prefix)
+		if err != nil {
+			return nil, err
+		}
+		for k, _, err := i.Next(); err == nil; k, _, err = i.Next() {
+			v, err := db.store.Get(k)
+			if err != nil {
+				return nil, err
+			}
+			if v == nil {
+				problems = append(problems, Problem{Key: string(k), Reason: "missing"})
+			}
+		}
+	}
+	return problems, nil
+}
+
+// checkSymmetry makes sure each datom appears in every index declared for its attribute
+func (db *DB) checkSymmetry() ([]Problem, error) {
+	var problems []Problem
+	for _, index := range indexes {
+		i, err := db.store.Prefix(NewKey(index).Prefix())
+		if err != nil {
+			return nil, err
+		}
+		for k, v, err := i.Next(); err == nil; k, v, err = i.Next() {
+			d, ok := parseIndexKey(index, k, v)
+			if !ok {
+				problems = append(problems, Problem{Key: string(k), Reason: "can't parse key"})
+				continue
+			}
+			val := []byte(fmt.Sprintf("%v", d.Value))
+			id := []byte(d.FeedID + ":" + d.EntityID)
+			idx := db.attrIndex(d.Attribute)
+			type entry struct {
+				key []byte
+				val []byte
+			}
+			want := []entry{{d.EAVKey(), val}}
+			if idx&IndexAEV != 0 {
+				want = append(want, entry{d.AEVKey(), val})
+			}
+			if idx.hasAVE() {
+				want = append(want, entry{d.AVEKey(), id})
+			}
+			if idx&IndexVAE != 0 {
+				want = append(want, entry{d.VAEKey(), id})
+			}
+			for _, w := range want {
+				if bytes.Equal(w.key, k) {
+					continue
+				}
+				got, err := db.store.Get(w.key)
+				if err != nil {
+					return nil, err
+				}
+				if got == nil {
+					problems = append(problems, Problem{Key: string(k), Reason: "no matching " + string(w.key)})
+				} else if !bytes.Equal(got, w.val) {
+					problems = append(problems, Problem{Key: string(k), Reason: fmt.Sprintf("%s is %q, expected %q", w.key, got, w.val)})
+				}
+			}
+		}
+	}
+	return problems, nil
+}
+
+// checkOrphans finds entities that have lost their system keys, or have nothing else
+func (db *DB) checkOrphans() ([]Problem, error) {
+	attrs := make(map[string]map[string]bool)
+	var ids []string
+	i, err := db.store.Prefix(NewKey("eav").Prefix())
+	if err != nil {
+		return nil, err
+	}
+	for k, v, err := i.Next(); err == nil; k, v, err = i.Next() {
+		d, ok := parseIndexKey("eav", k, v)
+		if !ok {
+			continue // checkSymmetry reports these
+		}
+		id := d.FeedID + ":" + d.EntityID
+		if _, ok := attrs[id]; !ok {
+			attrs[id] = make(map[string]bool)
+			ids = append(ids, id)
+		}
+		attrs[id][d.Attribute] = true
+	}
+
+	var problems []Problem
+	for _, id := range ids {
+		as := attrs[id]
+		key := string(NewKey("eav", id).ToBytes())
+		if as["db/Kind"] && !as["db/ID"] {
+			problems = append(problems, Problem{Key: key, Reason: "has db/Kind but no db/ID"})
+		}
+		if as["db/ID"] && !as["db/Kind"] {
+			problems = append(problems, Problem{Key: key, Reason: "has db/ID but no db/Kind"})
+		}
+		orphan := true
+		for attr := range as {
+			if !strings.HasPrefix(attr, "db/") {
+				orphan = false
+				break
+			}
+		}
+		if orphan {
+			problems = append(problems, Problem{Key: key, Reason: "has only db/ attributes"})
+		}
+	}
+	return problems, nil
+}
+
+// parseIndexKey recovers the datom behind an index entry
+func parseIndexKey(index string, k []byte, v []byte) (*Datom, bool) {
+	components := ParseKey(k).Components()
+	var id, attr, value string
+	switch {
+	case index == "eav" && len(components) == 3:
+		// eav/feed:entity/kind%2Fattr
+		id, attr, value = components[1], components[2], string(v)
+	case index == "aev" && len(components) == 3:
+		// aev/kind%2Fattr/feed:entity
+		id, attr, value = components[2], components[1], string(v)
+	case index == "ave" && len(components) == 4:
+		// ave/kind%2Fattr/value/feed:entity
+		id, attr, value = components[3], components[1], components[2]
+	case index == "vae" && len(components) == 4:
+		// vae/value/kind%2Fattr/feed:entity
+		id, attr, value = components[3], components[2], components[1]
+	default:
+		return nil, false
+	}
+	parts := strings.SplitN(id, ":", 2)
+	if len(parts) != 2 {
+		return nil, false
+	}
+	return &Datom{FeedID: parts[0], EntityID: parts[1], Attribute: attr, Value: value, Added: true}, true
+}
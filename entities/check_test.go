@@ -0,0 +1,85 @@
+package entities
+
+import (
+	"testing"
+)
+
+func TestCheckAndRepair(t *testing.T) {
+	tf := newTestFeed(t)
+	tf.append(t, noteOp("1", "a")).append(t, noteOp("2", "b"))
+	title := func(eid, value string) *Datom {
+		return &Datom{FeedID: tf.fp, EntityID: eid, Attribute: "Note/Title", Value: value, Added: true}
+	}
+	stray := &Datom{FeedID: "stray", EntityID: "1", Attribute: "Note/Title", Value: "c", Added: true}
+
+	cases := []struct {
+		name   string
+		damage func(s Store)
+		want   []Problem
+	}{
+		{
+			name:   "corrupt",
+			damage: func(s Store) { s.Set(title("1", "a").EAVKey(), []byte("x")) },
+			want: []Problem{
+				{string(title("1", "a").EAVKey()), `value is "x", feeds say "a"`},
+				{string(title("1", "x").EAVKey()), "no matching " + string(title("1", "x").AVEKey())},
+			},
+		},
+		{
+			name:   "missing",
+			damage: func(s Store) { s.Delete(title("2", "b").AEVKey()) },
+			want: []Problem{
+				{string(title("2", "b").AEVKey()), "missing"},
+				{string(title("2", "b").EAVKey()), "no matching " + string(title("2", "b").AEVKey())},
+			},
+		},
+		{
+			name:   "stray",
+			damage: func(s Store) { s.Set(stray.EAVKey(), []byte("c")) },
+			want: []Problem{
+				{string(stray.EAVKey()), "not produced by any feed"},
+				{string(stray.EAVKey()), "no matching " + string(stray.AEVKey())},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		db := newTestDB()
+		if err := db.OpenIndexes(); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.PutFeed(tf.signed(t, db)); err != nil {
+			t.Fatal(err)
+		}
+		if problems, err := db.Check(); err != nil || len(problems) != 0 {
+			t.Fatalf("%s: before the damage got %v, %v", c.name, problems, err)
+		}
+
+		c.damage(db.store)
+		problems, err := db.Check()
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := make(map[Problem]bool)
+		for _, p := range problems {
+			found[p] = true
+		}
+		for _, w := range c.want {
+			if !found[w] {
+				t.Errorf("%s: didn't find %v in %v", c.name, w, problems)
+			}
+		}
+
+		// what fsck --repair does
+		db.store.Delete(keyFormatKey())
+		if err := db.RebuildIndexes(); err != nil {
+			t.Fatal(err)
+		}
+		if problems, err := db.Check(); err != nil || len(problems) != 0 {
+			t.Errorf("%s: after repair got %v, %v", c.name, problems, err)
+		}
+		if v, err := db.store.Get(keyFormatKey()); err != nil || string(v) != "1" {
+			t.Errorf("%s: repair left the key format at %q, %v", c.name, v, err)
+		}
+	}
+}
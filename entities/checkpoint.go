@@ -0,0 +1,137 @@
+package entities
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/awans/mark/feed"
+)
+
+// indexFormat is bumped whenever the way ops are applied to the indexes changes,
+// so that old checkpoints force a full replay
+const indexFormat = 2
+
+// checkpointInterval is how often the checkpoint is written as feeds are applied
+// Ops are safe to apply twice, so a checkpoint that's a little behind only costs a short replay
+const checkpointInterval = 30 * time.Second
+
+// checkpoint records how many ops of each feed the indexes reflect
+type checkpoint struct {
+	Format int            `json:"format"`
+	Config string         `json:"config"`
+	Feeds  map[string]int `json:"feeds"`
+}
+
+func checkpointKey() []byte {
+	return NewKey("meta", "checkpoint").ToBytes()
+}
+
+// OpenIndexes brings the indexes up to date with the stored feeds
+// Only ops after the last checkpoint are replayed; everything is rebuilt
+// if there's no checkpoint, or it was written by a different format or index config
+func (db *DB) OpenIndexes() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	v, err := db.store.Get(checkpointKey())
+	if err != nil {
+		return err
+	}
+	if v == nil {
+		return db.rebuildIndexes(context.Background())
+	}
+	var cp checkpoint
+	err = json.Unmarshal(v, &cp)
+	if err != nil || cp.Format != indexFormat || cp.Config != db.indexConfig() || cp.Feeds == nil {
+		return db.rebuildIndexes(context.Background())
+	}
+	db.applied = cp.Feeds
+	db.indexed = true
+
+	fi, err := db.store.Prefix(NewKey("feed").Prefix())
+	if err != nil {
+		return err
+	}
+	for _, v, err := fi.Next(); err == nil; _, v, err = fi.Next() {
+		var sf feed.SignedFeed
+		err = json.Unmarshal(v, &sf)
+		if err != nil {
+			return err
+		}
+		fp, err := sf.Fingerprint()
+		if err != nil {
+			return err
+		}
+		applied := db.applied[fp]
+		if applied == len(sf) {
+			continue
+		}
+		if applied > len(sf) {
+			// the feed got shorter under us; we can't unapply ops
+			return db.rebuildIndexes(context.Background())
+		}
+		f, err := db.c.DecodeFrom(sf, applied)
+		if err != nil {
+			return err
+		}
+		n, err := db.applyOps(context.Background(), f.Ops, fp)
+		if err != nil {
+			return err
+		}
+		db.applied[fp] = applied + n
+	}
+	return db.saveCheckpoint()
+}
+
+// indexConfig summarizes the index declarations, since changing them changes the indexes
+func (db *DB) indexConfig() string {
+	var decls []string
+	for attr, idx := range db.attrIndexes {
+		decls = append(decls, fmt.Sprintf("%s=%d", attr, idx))
+	}
+	sort.Strings(decls)
+	sha := sha256.Sum256([]byte(strings.Join(decls, "\n")))
+	return base64.RawURLEncoding.EncodeToString(sha[:])
+}
+
+// saveCheckpoint records how much of each feed the indexes reflect
+// It does nothing unless the indexes are in a state worth keeping
+func (db *DB) saveCheckpoint() error {
+	if !db.indexed {
+		return nil
+	}
+	cp := checkpoint{Format: indexFormat, Config: db.indexConfig(), Feeds: db.applied}
+	bytes, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	db.lastCheckpoint = time.Now()
+	return db.store.Set(checkpointKey(), bytes)
+}
+
+// maybeCheckpoint saves the checkpoint if it hasn't been saved in a while
+func (db *DB) maybeCheckpoint() error {
+	if time.Since(db.lastCheckpoint) < checkpointInterval {
+		return nil
+	}
+	return db.saveCheckpoint()
+}
+
+// extends says whether sf starts with the first n ops of old
+func extends(sf feed.SignedFeed, old feed.SignedFeed, n int) bool {
+	if len(sf) < n || len(old) < n {
+		return false
+	}
+	for i := 0; i < n; i++ {
+		if sf[i] != old[i] {
+			return false
+		}
+	}
+	return true
+}
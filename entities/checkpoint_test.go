@@ -0,0 +1,224 @@
+package entities
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/awans/mark/feed"
+)
+
+// staleKey is an index entry no feed wrote; only a rebuild clears it out
+var staleKey = NewKey("eav", "stale:1", "Note/Title").ToBytes()
+
+func rebuilt(t *testing.T, db *DB) bool {
+	v, err := db.store.Get(staleKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v == nil
+}
+
+func noteTitles(t *testing.T, db *DB) map[string]string {
+	var notes []Note
+	err := db.NewQuery("Note").GetAll(&notes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	titles := make(map[string]string)
+	for _, n := range notes {
+		titles[n.ID] = n.Title
+	}
+	return titles
+}
+
+func TestExtends(t *testing.T) {
+	old := feed.SignedFeed{"a", "b", "c"}
+	cases := []struct {
+		name string
+		sf   feed.SignedFeed
+		n    int
+		want bool
+	}{
+		{"appends", feed.SignedFeed{"a", "b", "c", "d"}, 3, true},
+		{"same", feed.SignedFeed{"a", "b", "c"}, 3, true},
+		{"forks after what was applied", feed.SignedFeed{"a", "b", "x", "y"}, 2, true},
+		{"forks before what was applied", feed.SignedFeed{"a", "x", "c", "d"}, 3, false},
+		{"shorter", feed.SignedFeed{"a", "b"}, 3, false},
+		{"nothing applied", feed.SignedFeed{"x"}, 0, true},
+	}
+	for _, c := range cases {
+		if got := extends(c.sf, old, c.n); got != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestPutFeedReplaysOrRebuilds(t *testing.T) {
+	tf := newTestFeed(t)
+	tf.append(t, noteOp("1", "a")).append(t, noteOp("2", "b"))
+	id := func(eid string) string { return tf.fp + ":" + eid }
+
+	cases := []struct {
+		name    string
+		next    *testFeed
+		rebuilt bool
+		applied int
+		titles  map[string]string
+	}{
+		{
+			name:    "extends",
+			next:    tf.fork(3).append(t, noteOp("3", "c")),
+			applied: 4,
+			titles:  map[string]string{id("1"): "a", id("2"): "b", id("3"): "c"},
+		},
+		{
+			name:    "same",
+			next:    tf.fork(3),
+			applied: 3,
+			titles:  map[string]string{id("1"): "a", id("2"): "b"},
+		},
+		{
+			name:    "forks",
+			next:    tf.fork(2).append(t, noteOp("3", "c")),
+			rebuilt: true,
+			applied: 3,
+			titles:  map[string]string{id("1"): "a", id("3"): "c"},
+		},
+	}
+
+	for _, c := range cases {
+		db := newTestDB()
+		if err := db.OpenIndexes(); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.PutFeed(tf.signed(t, db)); err != nil {
+			t.Fatal(err)
+		}
+		db.store.Set(staleKey, []byte("stale"))
+
+		if err := db.PutFeed(c.next.signed(t, db)); err != nil {
+			t.Fatal(err)
+		}
+		if got := rebuilt(t, db); got != c.rebuilt {
+			t.Errorf("%s: rebuilt is %v, want %v", c.name, got, c.rebuilt)
+		}
+		if got := db.applied[tf.fp]; got != c.applied {
+			t.Errorf("%s: applied %d ops, want %d", c.name, got, c.applied)
+		}
+		if got := noteTitles(t, db); !reflect.DeepEqual(got, c.titles) {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.titles)
+		}
+	}
+}
+
+func TestOpenIndexesFromCheckpoint(t *testing.T) {
+	tf := newTestFeed(t)
+	tf.append(t, noteOp("1", "a")).append(t, noteOp("2", "b"))
+	id := func(eid string) string { return tf.fp + ":" + eid }
+
+	cases := []struct {
+		name string
+		// stored is the feed in the store when the db is next opened
+		stored  *testFeed
+		prepare func(db *DB)
+		rebuilt bool
+		applied int
+		titles  map[string]string
+	}{
+		{
+			name:    "nothing new",
+			stored:  tf,
+			applied: 3,
+			titles:  map[string]string{id("1"): "a", id("2"): "b"},
+		},
+		{
+			name:    "new ops",
+			stored:  tf.fork(3).append(t, noteOp("3", "c")),
+			applied: 4,
+			titles:  map[string]string{id("1"): "a", id("2"): "b", id("3"): "c"},
+		},
+		{
+			name:    "feed got shorter",
+			stored:  tf.fork(2),
+			rebuilt: true,
+			applied: 2,
+			titles:  map[string]string{id("1"): "a"},
+		},
+		{
+			name:    "index declarations changed",
+			stored:  tf,
+			prepare: func(db *DB) { db.DeclareIndex("Note", "Title", IndexFullText) },
+			rebuilt: true,
+			applied: 3,
+			titles:  map[string]string{id("1"): "a", id("2"): "b"},
+		},
+		{
+			name:    "no checkpoint",
+			stored:  tf,
+			prepare: func(db *DB) { db.store.Delete(checkpointKey()) },
+			rebuilt: true,
+			applied: 3,
+			titles:  map[string]string{id("1"): "a", id("2"): "b"},
+		},
+	}
+
+	for _, c := range cases {
+		store := newMemStore()
+		db := NewDB(store, "me", nil)
+		if err := db.OpenIndexes(); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.PutFeed(tf.signed(t, db)); err != nil {
+			t.Fatal(err)
+		}
+		db.Close()
+
+		db = NewDB(store, "me", nil)
+		b, err := json.Marshal(c.stored.signed(t, db))
+		if err != nil {
+			t.Fatal(err)
+		}
+		store.Set(NewKey("feed", tf.fp).ToBytes(), b)
+		store.Set(staleKey, []byte("stale"))
+		if c.prepare != nil {
+			c.prepare(db)
+		}
+
+		if err := db.OpenIndexes(); err != nil {
+			t.Fatal(err)
+		}
+		if got := rebuilt(t, db); got != c.rebuilt {
+			t.Errorf("%s: rebuilt is %v, want %v", c.name, got, c.rebuilt)
+		}
+		if got := db.applied[tf.fp]; got != c.applied {
+			t.Errorf("%s: applied %d ops, want %d", c.name, got, c.applied)
+		}
+		if got := noteTitles(t, db); !reflect.DeepEqual(got, c.titles) {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.titles)
+		}
+	}
+}
+
+func TestCloseWithoutIndexesKeepsCheckpoint(t *testing.T) {
+	store := newMemStore()
+	db := NewDB(store, "me", nil)
+	if err := db.OpenIndexes(); err != nil {
+		t.Fatal(err)
+	}
+	tf := newTestFeed(t)
+	tf.append(t, noteOp("1", "a"))
+	if err := db.PutFeed(tf.signed(t, db)); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	want, _ := store.Get(checkpointKey())
+
+	// eg mark fsck, which never opens the indexes
+	db = NewDB(store, "me", nil)
+	db.Close()
+	got, _ := store.Get(checkpointKey())
+	if string(got) != string(want) {
+		t.Errorf("got checkpoint %s, want %s", got, want)
+	}
+}
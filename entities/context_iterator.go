@@ -0,0 +1,21 @@
+package entities
+
+import "context"
+
+// contextIterator stops the iterators above it once ctx is done
+type contextIterator struct {
+	ctx   context.Context
+	inner queryIterator
+}
+
+func newContextIterator(ctx context.Context, inner queryIterator) *contextIterator {
+	i := contextIterator{ctx: ctx, inner: inner}
+	return &i
+}
+
+func (i *contextIterator) Next() (string, error) {
+	if err := i.ctx.Err(); err != nil {
+		return "", err
+	}
+	return i.inner.Next()
+}
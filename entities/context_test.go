@@ -0,0 +1,123 @@
+package entities
+
+import (
+	"context"
+	"testing"
+)
+
+func cancelled() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
+
+func TestWritesStopOnceCancelled(t *testing.T) {
+	cases := []struct {
+		name  string
+		write func(db *DB, id string) error
+	}{
+		{"put", func(db *DB, id string) error { return db.PutContext(cancelled(), id, &Note{Title: "b"}) }},
+		{"upsert", func(db *DB, id string) error {
+			_, err := db.UpsertContext(cancelled(), "Note", "Title", "a", &Note{Title: "b"})
+			return err
+		}},
+		{"remove", func(db *DB, id string) error { return db.RemoveContext(cancelled(), id) }},
+	}
+
+	for _, c := range cases {
+		db := newUserDB(t)
+		db.DeclareIndex("Note", "Title", IndexAVE)
+		id, err := db.Add(&Note{Title: "a"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		before, err := db.UserFeed()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := c.write(db, id); err != context.Canceled {
+			t.Errorf("%s: got %v, want %v", c.name, err, context.Canceled)
+		}
+		after, err := db.UserFeed()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(after.Ops) != len(before.Ops) {
+			t.Errorf("%s: wrote %d ops", c.name, len(after.Ops)-len(before.Ops))
+		}
+		var n Note
+		if err := db.Get(id, &n); err != nil || n.Title != "a" {
+			t.Errorf("%s: got %q, %v", c.name, n.Title, err)
+		}
+	}
+}
+
+func TestPutFeedContext(t *testing.T) {
+	tf := newTestFeed(t)
+	tf.append(t, noteOp("1", "a")).append(t, noteOp("2", "b"))
+	db := newTestDB()
+	if err := db.OpenIndexes(); err != nil {
+		t.Fatal(err)
+	}
+	sf := tf.signed(t, db)
+
+	if err := db.PutFeedContext(cancelled(), sf); err != context.Canceled {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+	// the feed is kept, but none of its ops were applied
+	if stored, err := db.GetFeed(tf.fp); err != nil || len(stored) != len(sf) {
+		t.Errorf("stored %d ops, %v", len(stored), err)
+	}
+	if titles := noteTitles(t, db); len(titles) != 0 {
+		t.Errorf("applied %v", titles)
+	}
+
+	// putting it again picks up where the cancelled put stopped
+	if err := db.PutFeed(sf); err != nil {
+		t.Fatal(err)
+	}
+	if titles := noteTitles(t, db); len(titles) != 2 {
+		t.Errorf("got %v", titles)
+	}
+}
+
+func TestRebuildIndexesContext(t *testing.T) {
+	tf := newTestFeed(t)
+	tf.append(t, noteOp("1", "a"))
+	db := newTestDB()
+	if err := db.OpenIndexes(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutFeed(tf.signed(t, db)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.RebuildIndexesContext(cancelled()); err != context.Canceled {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+	// a cancelled rebuild leaves no checkpoint, so the next open rebuilds
+	if v, err := db.store.Get(checkpointKey()); err != nil || v != nil {
+		t.Errorf("checkpoint is %q, %v", v, err)
+	}
+	if err := db.OpenIndexes(); err != nil {
+		t.Fatal(err)
+	}
+	if titles := noteTitles(t, db); titles[tf.fp+":1"] != "a" {
+		t.Errorf("got %v", titles)
+	}
+}
+
+func TestGetAllContext(t *testing.T) {
+	db := newTestDB()
+	applyEntity(db, "f:1", "Note", map[string]interface{}{"Note/Title": "a"})
+
+	var notes []Note
+	err := db.NewQuery("Note").GetAllContext(cancelled(), &notes)
+	if err != context.Canceled {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+	if err := db.NewQuery("Note").GetAllContext(context.Background(), &notes); err != nil || len(notes) != 1 {
+		t.Errorf("got %v, %v", notes, err)
+	}
+}
@@ -2,13 +2,18 @@ package entities
 
 import (
 	"bytes"
+	"context"
 	"crypto/rsa"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/awans/mark/feed"
@@ -16,12 +21,55 @@ import (
 	"github.com/square/go-jose"
 )
 
+// ErrNoSuchEntity is returned for ids that have nothing in the eav index
+var ErrNoSuchEntity = errors.New("No such entity")
+
+// MultiError holds an error, or nil, for each id passed to GetMulti
+type MultiError []error
+
+func (m MultiError) Error() string {
+	var first error
+	n := 0
+	for _, err := range m {
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			n++
+		}
+	}
+	if n <= 1 {
+		return fmt.Sprintf("%v", first)
+	}
+	return fmt.Sprintf("%v (and %d other errors)", first, n-1)
+}
+
 // DB is the access point to the entity DB
+// It's safe for concurrent use: writes to the user's feed and index rebuilds
+// take the lock exclusively, so readers never see a half-built index
 type DB struct {
+	// version counts changes to the feeds and pubs, so servers know when to recompute
+	// It's first so it's 64-bit aligned for atomic access
+	version uint64
+
+	mu    sync.RWMutex
 	store Store
 	fp    string
 	c     *feed.Coder
 	key   *rsa.PrivateKey // maybe hide this
+
+	migrations  map[string]migrations
+	attrIndexes map[string]Index
+
+	entityCache *lru
+	queryCache  *lru
+
+	// applied is how many ops of each feed are in the indexes
+	applied        map[string]int
+	lastCheckpoint time.Time
+	// indexed says applied matches the indexes, so it's safe to checkpoint;
+	// it isn't until they're opened, or while a rebuild is unfinished
+	indexed bool
 }
 
 // NewQuery is not implemented yet
@@ -43,29 +91,99 @@ func ConvertJWK(bytes []byte) (interface{}, error) {
 	return &jwk, err
 }
 
+// ConvertPubDeclaration implements Converter
+func ConvertPubDeclaration(bytes []byte) (interface{}, error) {
+	var d feed.PubDeclaration
+	err := json.Unmarshal(bytes, &d)
+	return &d, err
+}
+
+// ConvertBlock implements Converter
+func ConvertBlock(bytes []byte) (interface{}, error) {
+	var b feed.Block
+	err := json.Unmarshal(bytes, &b)
+	return &b, err
+}
+
 // NewDB is a constructor for a db
 func NewDB(store Store, fp string, key *rsa.PrivateKey) *DB {
 	c := feed.NewCoder()
 	c.RegisterOp("eav", ConvertDatoms)
+	c.RegisterOp(sharedOpName, ConvertSharedOp)
+	c.RegisterOp("declare-pub", ConvertPubDeclaration)
+	c.RegisterOp("block", ConvertBlock)
 	c.RegisterOp("declare-key", ConvertJWK)
 
-	return &DB{store: store, fp: fp, c: c, key: key}
+	return &DB{
+		store:       store,
+		fp:          fp,
+		c:           c,
+		key:         key,
+		migrations:  make(map[string]migrations),
+		attrIndexes: sysIndexes(),
+		entityCache: newLRU(entityCacheSize),
+		queryCache:  newLRU(queryCacheSize),
+		applied:     make(map[string]int),
+	}
 }
 
-// Close closes the db
+// Close checkpoints the indexes, if they were opened and aren't half rebuilt, and closes the db
 func (db *DB) Close() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.saveCheckpoint()
 	db.store.Close()
 }
 
 // RebuildIndexes deletes all keys in the eav indexes and then loads each feed
-// TODO rewrite this using GetFeeds
+// OpenIndexes is usually enough, and much faster
 func (db *DB) RebuildIndexes() error {
-	for _, index := range []string{"eav", "aev", "ave", "vae"} {
-		p, err := db.store.Prefix(NewKey(index).ToBytes())
+	return db.RebuildIndexesContext(context.Background())
+}
+
+// RebuildIndexesContext is RebuildIndexes, but stops between ops once ctx is done
+// The checkpoint is only saved at the end, so a cancelled rebuild runs again on the next start
+func (db *DB) RebuildIndexesContext(ctx context.Context) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.rebuildIndexes(ctx)
+}
+
+// batch runs fn with the store's writes committed in batches
+// The caller must hold the write lock, since fn sees db.store as the batch
+func (db *DB) batch(fn func() error) error {
+	store := db.store
+	defer func() { db.store = store }()
+	return store.Update(func(s Store) error {
+		db.store = s
+		return fn()
+	})
+}
+
+// rebuildIndexes writes the indexes in batches rather than a key at a time
+func (db *DB) rebuildIndexes(ctx context.Context) error {
+	return db.batch(func() error {
+		return db.replayFeeds(ctx)
+	})
+}
+
+// TODO rewrite this using GetFeeds
+func (db *DB) replayFeeds(ctx context.Context) error {
+	db.clearCaches()
+	// if we die or are cancelled halfway through, the next start has to rebuild too
+	db.store.Delete(checkpointKey())
+	db.applied = make(map[string]int)
+	db.indexed = false
+
+	for _, index := range append(indexes, "fts", "lww", "declared", "blocked") {
+		p, err := db.store.Prefix(NewKey(index).Prefix())
 		if err != nil {
 			return err
 		}
 		for k, _, err := p.Next(); err == nil; k, _, err = p.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 			db.store.Delete(k)
 		}
 	}
@@ -79,28 +197,96 @@ func (db *DB) RebuildIndexes() error {
 		if err != nil {
 			return err
 		}
+		fp, err := sf.Fingerprint()
+		if err != nil {
+			return err
+		}
 		feed, err := db.c.Decode(sf)
 		if err != nil {
 			return err
 		}
-		db.LoadFeed(feed)
+		n, err := db.applyOps(ctx, feed.Ops, fp)
+		db.applied[fp] = n
+		if err != nil {
+			return err
+		}
 	}
-	return nil
+	// whatever format the indexes were in, they're in this one now
+	err = db.store.Set(keyFormatKey(), []byte(strconv.Itoa(keyFormat)))
+	if err != nil {
+		return err
+	}
+	db.indexed = true
+	return db.saveCheckpoint()
+}
+
+// keyFormat is the version of the index key encoding
+// 1: key components are escaped, so values can contain the separator
+const keyFormat = 1
+
+func keyFormatKey() []byte {
+	return NewKey("meta", "keyformat").ToBytes()
+}
+
+// MigrateKeys rewrites the indexes if they were written with an older key encoding
+// Feeds and pubs are keyed by base64url ids, which encode the same either way,
+// so only the indexes need replaying, and any rebuild leaves them in the current format
+func (db *DB) MigrateKeys() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	v, err := db.store.Get(keyFormatKey())
+	if err != nil {
+		return err
+	}
+	if string(v) == strconv.Itoa(keyFormat) {
+		return nil
+	}
+	return db.rebuildIndexes(context.Background())
 }
 
 // LoadFeed applies each op to the db in turn and saves it under the user/feed key
 func (db *DB) LoadFeed(feed *feed.Feed) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.loadFeed(feed)
+}
+
+func (db *DB) loadFeed(feed *feed.Feed) error {
 	fp, err := feed.Fingerprint()
 	if err != nil {
 		return err
 	}
-	for _, op := range feed.Ops {
+	_, err = db.applyOps(context.Background(), feed.Ops, fp)
+	return err
+}
+
+// applyOps returns how many ops it applied before ctx was done
+// Ops are applied whole, so the indexes never hold half of one
+func (db *DB) applyOps(ctx context.Context, ops []feed.Op, fp string) (int, error) {
+	for n, op := range ops {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
 		db.applyOp(op, fp)
 	}
-	return nil
+	return len(ops), nil
 }
 
 func (db *DB) applyOp(op feed.Op, fp string) {
+	if op.Op == "declare-pub" {
+		// the latest declaration wins
+		db.store.Set(declaredKey(fp), []byte(op.Body.(*feed.PubDeclaration).URL))
+		return
+	}
+	if op.Op == "block" {
+		db.applyBlock(op.Body.(*feed.Block), fp)
+		return
+	}
+	if op.Op == sharedOpName {
+		db.applySharedOp(op.Body.(*sharedOp), fp)
+		return
+	}
 	if op.Op != "eav" {
 		return
 	}
@@ -108,6 +294,11 @@ func (db *DB) applyOp(op feed.Op, fp string) {
 	entityIDs := make(map[string]bool)
 	for _, datom := range datoms {
 		datom.FeedID = fp
+		if id := fp + ":" + datom.EntityID; db.isShared(id) {
+			// plain ops count as written at time zero
+			db.recordWrite(id, datom.Attribute, fp, write{Value: fmt.Sprintf("%v", datom.Value), Added: datom.Added})
+			continue
+		}
 		db.applyDatom(datom)
 		if datom.Added {
 			entityIDs[datom.EntityID] = true
@@ -120,6 +311,9 @@ func (db *DB) applyOp(op feed.Op, fp string) {
 
 // GetFeeds returns all feed.SignedFeeds
 func (db *DB) GetFeeds() ([]feed.SignedFeed, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
 	var feeds []feed.SignedFeed
 
 	feedK := NewKey("feed")
@@ -140,6 +334,9 @@ func (db *DB) GetFeeds() ([]feed.SignedFeed, error) {
 
 // GetFeed returns a single SignedFeed by id
 func (db *DB) GetFeed(id string) (feed.SignedFeed, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
 	feedK := NewKey("feed", id)
 	feedBytes, err := db.store.Get(feedK.ToBytes())
 	if err != nil {
@@ -155,6 +352,12 @@ func (db *DB) GetFeed(id string) (feed.SignedFeed, error) {
 
 // UserFeed loads the feed for the user in this session
 func (db *DB) UserFeed() (*feed.Feed, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.userFeed()
+}
+
+func (db *DB) userFeed() (*feed.Feed, error) {
 	feedK := NewKey("feed", string(db.fp))
 	feedBytes, err := db.store.Get(feedK.ToBytes())
 	if err != nil {
@@ -170,16 +373,26 @@ func (db *DB) UserFeed() (*feed.Feed, error) {
 
 // PutUserFeed sets a user's feed in the db
 func (db *DB) PutUserFeed(f *feed.Feed) (feed.SignedFeed, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.putUserFeed(f)
+}
+
+func (db *DB) putUserFeed(f *feed.Feed) (feed.SignedFeed, error) {
 	sf, err := db.c.Encode(f, db.key)
 	if err != nil {
 		return nil, err
 	}
-	return sf, db.PutFeed(sf)
+	// our own op is already signed and stored, so it's always applied
+	return sf, db.putFeed(context.Background(), sf)
 }
 
 // RebuildUserFeed recreates the user's feed from ops
 func (db *DB) RebuildUserFeed() error {
-	oldFeed, err := db.UserFeed()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	oldFeed, err := db.userFeed()
 	if err != nil {
 		return err
 	}
@@ -190,12 +403,27 @@ func (db *DB) RebuildUserFeed() error {
 	for _, op := range oldFeed.Ops {
 		newFeed.Append(op, db.key)
 	}
-	_, err = db.PutUserFeed(newFeed)
+	_, err = db.putUserFeed(newFeed)
 	return err
 }
 
 // PutFeed sets a feed in the store
 func (db *DB) PutFeed(sf feed.SignedFeed) error {
+	return db.PutFeedContext(context.Background(), sf)
+}
+
+// PutFeedContext is PutFeed, but stops between ops once ctx is done
+// The feed is stored either way; the ops that weren't applied are applied
+// the next time the feed is put, or when the indexes are next opened
+func (db *DB) PutFeedContext(ctx context.Context, sf feed.SignedFeed) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.putFeed(ctx, sf)
+}
+
+// putFeed only applies the ops the indexes haven't seen,
+// unless the new feed doesn't extend the one they were built from
+func (db *DB) putFeed(ctx context.Context, sf feed.SignedFeed) error {
 	fp, err := sf.Fingerprint()
 	if err != nil {
 		return err
@@ -205,60 +433,264 @@ func (db *DB) PutFeed(sf feed.SignedFeed) error {
 		return err
 	}
 	feedK := NewKey("feed", fp)
+
+	applied := db.applied[fp]
+	if applied > 0 {
+		oldBytes, err := db.store.Get(feedK.ToBytes())
+		if err != nil {
+			return err
+		}
+		var old feed.SignedFeed
+		err = json.Unmarshal(oldBytes, &old)
+		if err != nil || !extends(sf, old, applied) {
+			db.store.Set(feedK.ToBytes(), feedBytes)
+			db.changed()
+			return db.rebuildIndexes(ctx)
+		}
+	}
+
+	f, err := db.c.DecodeFrom(sf, applied)
+	if err != nil {
+		return err
+	}
 	db.store.Set(feedK.ToBytes(), feedBytes)
-	db.RebuildIndexes() // TODO this is too much work
-	return nil
+	db.changed()
+	n, err := db.applyOps(ctx, f.Ops, fp)
+	db.applied[fp] = applied + n
+	if err != nil {
+		return err
+	}
+	return db.maybeCheckpoint()
+}
+
+// DropFeed removes a feed and everything it put in the indexes
+// Sync will fetch it again if a pub still has it
+func (db *DB) DropFeed(id string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.dropFeed(id)
+}
+
+func (db *DB) dropFeed(id string) error {
+	if id == db.fp {
+		return errors.New("Can't drop your own feed")
+	}
+	feedK := NewKey("feed", id).ToBytes()
+	v, err := db.store.Get(feedK)
+	if err != nil {
+		return err
+	}
+	if v == nil {
+		return errors.New("No such feed: " + id)
+	}
+
+	// every index key ends with, or for eav starts with, feed:entity
+	owned := id + ":"
+	for _, index := range append(indexes, "fts") {
+		p, err := db.store.Prefix(NewKey(index).Prefix())
+		if err != nil {
+			return err
+		}
+		var drop [][]byte
+		for k, _, err := p.Next(); err == nil; k, _, err = p.Next() {
+			components := ParseKey(k).Components()
+			eid := components[len(components)-1]
+			if index == "eav" {
+				eid = components[1]
+			}
+			if strings.HasPrefix(eid, owned) {
+				drop = append(drop, k)
+			}
+		}
+		for _, k := range drop {
+			db.store.Delete(k)
+		}
+	}
+	err = db.dropWrites(id)
+	if err != nil {
+		return err
+	}
+	db.store.Delete(declaredKey(id))
+	err = db.deletePrefix(NewKey("blocked", id).Prefix())
+	if err != nil {
+		return err
+	}
+
+	db.store.Delete(feedK)
+	db.changed()
+	delete(db.applied, id)
+	db.clearCaches()
+	return db.saveCheckpoint()
 }
 
 // GetPubs returns all Pubs this node knows about
 func (db *DB) GetPubs() ([]feed.Pub, error) {
-	var pubs []feed.Pub
+	db.mu.RLock()
+	pubs, fixups, err := db.getPubs()
+	db.mu.RUnlock()
+	if err != nil || len(fixups) == 0 {
+		return pubs, err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for i := range fixups {
+		err = db.putPub(&fixups[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return pubs, nil
+}
+
+// getPubs returns the pubs, and the ones with bad data that needs writing back
+func (db *DB) getPubs() ([]feed.Pub, []feed.Pub, error) {
+	var pubs, fixups []feed.Pub
 
 	pubK := NewKey("pub")
 	i, err := db.store.Prefix(pubK.ToBytes())
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	for _, v, err := i.Next(); err == nil; _, v, err = i.Next() {
 		var pub feed.Pub
 		err = json.Unmarshal(v, &pub)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		// fixup bad data
 		if pub.LastUpdated == 0 {
 			pub.LastUpdated = time.Now().Unix()
-			db.PutPub(&pub)
+			fixups = append(fixups, pub)
 		}
 		pubs = append(pubs, pub)
 	}
-	return pubs, nil
+	return pubs, fixups, nil
+}
+
+// GetPub returns the pub with a url, or nil if this node doesn't know it
+func (db *DB) GetPub(url string) (*feed.Pub, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.getPub(url)
+}
+
+func (db *DB) getPub(url string) (*feed.Pub, error) {
+	p := feed.Pub{URL: url}
+	bytes, err := db.store.Get(NewKey("pub", string(p.URLHash())).ToBytes())
+	if err != nil || len(bytes) == 0 {
+		return nil, err
+	}
+	err = json.Unmarshal(bytes, &p)
+	return &p, err
 }
 
 // PutPub adds a pub to the collection this node knows about
 func (db *DB) PutPub(p *feed.Pub) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.putPub(p)
+}
+
+// AddPub puts a pub unless this node already knows it
+func (db *DB) AddPub(p *feed.Pub) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	old, err := db.getPub(p.URL)
+	if err != nil || old != nil {
+		return err
+	}
+	return db.putPub(p)
+}
+
+// UpdatePub changes this node's record of the pub at url with fn
+// The record is read and written under the lock, so changes made to it
+// since the caller last read it aren't lost; an unknown pub is left alone
+func (db *DB) UpdatePub(url string, fn func(*feed.Pub)) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	p, err := db.getPub(url)
+	if err != nil || p == nil {
+		return err
+	}
+	fn(p)
+	return db.putPub(p)
+}
+
+func (db *DB) putPub(p *feed.Pub) error {
 	bytes, err := json.Marshal(p)
 	if err != nil {
 		return err
 	}
 	k := NewKey("pub", string(p.URLHash()))
 	db.store.Set(k.ToBytes(), bytes)
+	db.changed()
 	return nil
 }
 
+// Ops returns the op types the db understands
+func (db *DB) Ops() []string {
+	return db.c.Ops()
+}
+
+// Version changes whenever a feed or pub does
+func (db *DB) Version() uint64 {
+	return atomic.LoadUint64(&db.version)
+}
+
+func (db *DB) changed() {
+	atomic.AddUint64(&db.version, 1)
+}
+
 // PutSelf sets the Pub that is this node
+// A new url is declared in the user's feed, so other nodes accept its announcements
 func (db *DB) PutSelf(p *feed.Pub) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
 	k := NewKey("pub", "self")
 	bytes, err := json.Marshal(p)
 	if err != nil {
 		return err
 	}
 	db.store.Set(k.ToBytes(), bytes)
-	return nil
+
+	declared, err := db.store.Get(declaredKey(db.fp))
+	if err != nil || string(declared) == p.URL {
+		return err
+	}
+	f, err := db.userFeed()
+	if err != nil {
+		return err
+	}
+	f.Append(*feed.DeclarePub(p.URL), db.key)
+	_, err = db.putUserFeed(f)
+	return err
+}
+
+// DeclaredPub returns the pub url a feed last declared, or "" if it hasn't
+func (db *DB) DeclaredPub(fp string) (string, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	v, err := db.store.Get(declaredKey(fp))
+	return string(v), err
+}
+
+func declaredKey(fp string) []byte {
+	return NewKey("declared", fp).ToBytes()
 }
 
 // GetSelf returns the Pub that is this node
 func (db *DB) GetSelf() (*feed.Pub, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.getSelf()
+}
+
+func (db *DB) getSelf() (*feed.Pub, error) {
 	pubK := NewKey("pub", "self")
 	bytes, err := db.store.Get(pubK.ToBytes())
 	if err != nil || len(bytes) == 0 {
@@ -270,13 +702,32 @@ func (db *DB) GetSelf() (*feed.Pub, error) {
 }
 
 func (db *DB) applyDatom(d Datom) {
-	// eav, aev, ave, vae
-	// we probably don't need all of these..
+	db.invalidate(d)
+
+	// eav always, the rest as declared
+	idx := db.attrIndex(d.Attribute)
+	if idx&IndexFullText != 0 {
+		db.applyFullText(d)
+	}
 	if d.Added {
+		old, err := db.store.Get(d.EAVKey())
+		if err == nil && old != nil && string(old) != fmt.Sprintf("%v", d.Value) {
+			// drop the entries for the value this replaces
+			stale := d
+			stale.Value = string(old)
+			db.store.Delete(stale.AVEKey())
+			db.store.Delete(stale.VAEKey())
+		}
 		db.store.Set(d.EAVKey(), []byte(fmt.Sprintf("%v", d.Value)))
-		db.store.Set(d.AEVKey(), []byte(fmt.Sprintf("%v", d.Value)))
-		db.store.Set(d.AVEKey(), []byte(d.FeedID+":"+d.EntityID))
-		db.store.Set(d.VAEKey(), []byte(d.FeedID+":"+d.EntityID))
+		if idx&IndexAEV != 0 {
+			db.store.Set(d.AEVKey(), []byte(fmt.Sprintf("%v", d.Value)))
+		}
+		if idx.hasAVE() {
+			db.store.Set(d.AVEKey(), []byte(d.FeedID+":"+d.EntityID))
+		}
+		if idx&IndexVAE != 0 {
+			db.store.Set(d.VAEKey(), []byte(d.FeedID+":"+d.EntityID))
+		}
 	} else {
 		// be smarter here so we don't have to save the value on removal
 		db.store.Delete(d.EAVKey())
@@ -315,9 +766,12 @@ func getKindFromInstance(instance interface{}) string {
 
 // GetAll returns all entities of a given type
 func (db *DB) GetAll(dst interface{}) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
 	kind := getKindFromSlicePtr(dst)
 	prefix := NewKey("ave", "db/Kind", kind)
-	i, err := db.store.Prefix(prefix.ToBytes())
+	i, err := db.store.Prefix(prefix.Prefix())
 	if err != nil {
 		return err
 	}
@@ -326,78 +780,303 @@ func (db *DB) GetAll(dst interface{}) error {
 	for _, v, err := i.Next(); err == nil; _, v, err = i.Next() {
 		entityIDs = append(entityIDs, string(v))
 	}
-	db.GetMulti(entityIDs, dst)
-	return nil
+	return db.getFound(entityIDs, dst)
 }
 
 // Get returns a single entity by id
+// Entities written with an older schema version are migrated on the way out
 func (db *DB) Get(id string, dst interface{}) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.get(id, dst)
+}
+
+func (db *DB) get(id string, dst interface{}) error {
+	entityType := reflect.ValueOf(dst).Elem().Type()
+	if c, ok := db.entityCache.get(id); ok {
+		if ce := c.(*cachedEntity); ce.val.Type() == entityType {
+			reflect.ValueOf(dst).Elem().Set(ce.val)
+			return nil
+		}
+	}
+
 	prefix := NewKey("eav", id)
-	i, err := db.store.Prefix(prefix.ToBytes())
+	i, err := db.store.Prefix(prefix.Prefix())
 	if err != nil {
 		return err
 	}
 
-	entityType := reflect.ValueOf(dst).Elem().Type()
+	rows := newEntityRows()
+	for k, v, err := i.Next(); err == nil; k, v, err = i.Next() {
+		rows.add(k, v)
+	}
+	if rows.empty() {
+		return ErrNoSuchEntity
+	}
+
+	entity, err := db.hydrate(entityType, rows)
+	if err != nil {
+		return err
+	}
+	reflect.ValueOf(dst).Elem().Set(entity)
+	db.entityCache.add(id, &cachedEntity{val: entity})
+	return nil
+}
+
+// entityRows are an entity's eav entries, split into its own attributes and db/ ones
+type entityRows struct {
+	attrs map[string]string
+	sys   map[string]string
+}
+
+func newEntityRows() *entityRows {
+	return &entityRows{attrs: make(map[string]string), sys: make(map[string]string)}
+}
+
+func (r *entityRows) add(k []byte, v []byte) {
+	// eav/feed1:123/user%2Fname = Andrew
+	attr := ParseKey(k).Components()[2]
+	if strings.HasPrefix(attr, "db/") {
+		r.sys[attr] = string(v)
+	} else {
+		r.attrs[attr[strings.Index(attr, "/")+1:]] = string(v)
+	}
+}
+
+func (r *entityRows) empty() bool {
+	return len(r.attrs) == 0 && len(r.sys) == 0
+}
+
+// hydrate migrates rows and sets them on a new entityType
+func (db *DB) hydrate(entityType reflect.Type, rows *entityRows) (reflect.Value, error) {
 	entity := reflect.New(entityType).Interface()
 
-	for k, v, err := i.Next(); err == nil; k, v, err = i.Next() {
-		components := bytes.Split(k, Separator)
-		// eav/feed1:123/user/name = Andrew
-		attr := string(components[3])
-		field := reflect.ValueOf(entity).Elem().FieldByName(attr)
-		if field.IsValid() {
-			switch field.Kind() {
-			case reflect.Int:
-				i, err := strconv.Atoi(string(v))
-				if err != nil {
-					fmt.Print(err)
-					continue
-				}
-				field.SetInt(int64(i))
-			case reflect.String:
-				sv := string(v)
-				field.SetString(sv)
-			default:
-				return errors.New("Bad type")
-			}
+	err := db.migrate(entityType.Name(), rows.attrs, rows.sys)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+
+	for attr, v := range rows.sys {
+		if attr == schemaVersionAttr {
+			continue
+		}
+		err = setField(entity, strings.TrimPrefix(attr, "db/"), v)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+	}
+	for attr, v := range rows.attrs {
+		err = setField(entity, attr, v)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+	}
+	return reflect.ValueOf(entity).Elem(), nil
+}
+
+// setField sets a struct field from its stored string value
+func setField(entity interface{}, attr string, v string) error {
+	field := reflect.ValueOf(entity).Elem().FieldByName(attr)
+	if !field.IsValid() {
+		return nil
+	}
+	switch field.Kind() {
+	case reflect.Int:
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			fmt.Print(err)
+			return nil
 		}
+		field.SetInt(int64(i))
+	case reflect.String:
+		field.SetString(v)
+	default:
+		return errors.New("Bad type")
 	}
-	reflect.ValueOf(dst).Elem().Set(reflect.ValueOf(entity).Elem())
 	return nil
 }
 
 // GetMulti fetches many keys
-// dst is a pointer to a slice
+// dst is a pointer to a slice, which gets one entry per id.
+// If any are missing or fail to load, the error is a MultiError
+// and those entries are left as zero values.
 func (db *DB) GetMulti(ids []string, dst interface{}) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.getMulti(ids, dst)
+}
+
+func (db *DB) getMulti(ids []string, dst interface{}) error {
 	v := reflect.ValueOf(dst).Elem() // v is a Value(sliceInstance)
 	entityType := v.Type().Elem()    // v is a V(sliceInstance)->T(sliceType)->T(inner type)
 
-	for _, id := range ids {
-		entity := reflect.New(entityType).Interface()
-		db.Get(id, entity)
-		v.Set(reflect.Append(v, reflect.ValueOf(entity).Elem()))
+	loaded := make([]reflect.Value, len(ids))
+	errs := make(MultiError, len(ids))
+	failed := false
+
+	var misses []string
+	for i, id := range ids {
+		if c, ok := db.entityCache.get(id); ok {
+			if ce := c.(*cachedEntity); ce.val.Type() == entityType {
+				loaded[i] = ce.val
+				continue
+			}
+		}
+		misses = append(misses, id)
+	}
+
+	rows, err := db.scanEntities(misses)
+	if err != nil {
+		return err
+	}
+
+	for i, id := range ids {
+		if loaded[i].IsValid() {
+			continue
+		}
+		r, ok := rows[id]
+		if !ok {
+			errs[i] = ErrNoSuchEntity
+			failed = true
+			loaded[i] = reflect.New(entityType).Elem()
+			continue
+		}
+		entity, err := db.hydrate(entityType, r)
+		if err != nil {
+			errs[i] = err
+			failed = true
+			loaded[i] = reflect.New(entityType).Elem()
+			continue
+		}
+		db.entityCache.add(id, &cachedEntity{val: entity})
+		loaded[i] = entity
 	}
+
+	for _, entity := range loaded {
+		v.Set(reflect.Append(v, entity))
+	}
+	if failed {
+		return errs
+	}
+	return nil
+}
+
+// getFound is getMulti for ids that came out of an index,
+// skipping any that don't resolve to an entity
+func (db *DB) getFound(ids []string, dst interface{}) error {
+	v := reflect.ValueOf(dst).Elem()
+	start := v.Len()
+	err := db.getMulti(ids, dst)
+	errs, ok := err.(MultiError)
+	if !ok {
+		return err
+	}
+
+	out := v.Slice(0, start)
+	for i, err := range errs {
+		if err == ErrNoSuchEntity {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		out = reflect.Append(out, v.Index(start+i))
+	}
+	v.Set(out)
 	return nil
 }
 
+// scanEntities loads the eav entries for each id in one pass over the index.
+// The ids are visited in key order; a run of ids that are adjacent in the index
+// is read with a single iterator, and it only seeks again to skip over entities
+// nobody asked for.
+func (db *DB) scanEntities(ids []string) (map[string]*entityRows, error) {
+	rows := make(map[string]*entityRows)
+	if len(ids) == 0 {
+		return rows, nil
+	}
+
+	prefixes := make(map[string][]byte)
+	var sorted []string
+	for _, id := range ids {
+		if _, ok := prefixes[id]; ok {
+			continue
+		}
+		prefixes[id] = NewKey("eav", id).Prefix()
+		sorted = append(sorted, id)
+	}
+	sort.Slice(sorted, func(a, b int) bool {
+		return bytes.Compare(prefixes[sorted[a]], prefixes[sorted[b]]) < 0
+	})
+
+	i, err := db.store.Range(prefixes[sorted[0]], nil, false)
+	if err != nil {
+		return nil, err
+	}
+	k, v, err := i.Next()
+	for n := 0; n < len(sorted) && err == nil; {
+		id := sorted[n]
+		prefix := prefixes[id]
+		switch {
+		case bytes.HasPrefix(k, prefix):
+			r, ok := rows[id]
+			if !ok {
+				r = newEntityRows()
+				rows[id] = r
+			}
+			r.add(k, v)
+			k, v, err = i.Next()
+		case bytes.Compare(k, prefix) < 0:
+			// an entity we don't want; skip ahead to the one we do
+			i, err = db.store.Range(prefix, nil, false)
+			if err != nil {
+				return nil, err
+			}
+			k, v, err = i.Next()
+		default:
+			n++
+		}
+	}
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return rows, nil
+}
+
 func eavOp(datoms []Datom) feed.Op {
 	op := feed.Op{Op: "eav", Body: datoms}
 	return op
 }
 
 func isSysKey(s string) bool {
-	return s == "ID" || s == "FeedID"
+	return s == "ID" || s == "FeedID" || s == "Writers"
 }
 
 // Put sets src at id
-// TODO load it first and store the delta
 func (db *DB) Put(id string, src interface{}) error {
+	return db.PutContext(context.Background(), id, src)
+}
+
+// PutContext is Put, but gives up if ctx is done before the op is written
+// Once it's signed and stored, the op is applied regardless
+func (db *DB) PutContext(ctx context.Context, id string, src interface{}) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return db.put(id, src)
+}
+
+// put appends to the user's feed, so the caller must hold the write lock
+// for the whole read-modify-write
+// TODO load it first and store the delta
+func (db *DB) put(id string, src interface{}) error {
 	kind := getKindFromInstance(src)
 	c := reflect.ValueOf(src).Elem()
 	cType := c.Type()
 
-	feed, err := db.UserFeed()
+	feed, err := db.userFeed()
 	if err != nil {
 		return err
 	}
@@ -406,14 +1085,15 @@ func (db *DB) Put(id string, src interface{}) error {
 		return err
 	}
 	parts := strings.Split(id, ":")
-	if parts[0] != fp {
+	shared := db.isShared(id)
+	if parts[0] != fp && !(shared && db.canWrite(id, fp)) {
 		return errors.New("Can't add something not in your feed")
 	}
-	eid := parts[1]
+	owner, eid := parts[0], parts[1]
 
 	var datoms []Datom
 	kd := Datom{
-		FeedID:    fp,
+		FeedID:    owner,
 		EntityID:  eid,
 		Attribute: "db/Kind",
 		Value:     kind,
@@ -421,6 +1101,17 @@ func (db *DB) Put(id string, src interface{}) error {
 	}
 	datoms = append(datoms, kd)
 
+	if version := db.schemaVersion(kind); version > 0 {
+		vd := Datom{
+			FeedID:    owner,
+			EntityID:  eid,
+			Attribute: schemaVersionAttr,
+			Value:     version,
+			Added:     true,
+		}
+		datoms = append(datoms, vd)
+	}
+
 	for i := 0; i < cType.NumField(); i++ {
 		valueField := c.Field(i)
 		typeField := cType.Field(i)
@@ -432,56 +1123,107 @@ func (db *DB) Put(id string, src interface{}) error {
 		attrName := kind + "/" + typeField.Name
 
 		d := Datom{
-			FeedID:    fp,
+			FeedID:    owner,
 			EntityID:  eid,
 			Attribute: attrName,
 			Value:     valueField.Interface(),
 			Added:     true,
 		}
+		err = db.checkUnique(d)
+		if err != nil {
+			return err
+		}
 		datoms = append(datoms, d)
 	}
 
+	if shared {
+		for i := range datoms {
+			datoms[i].EntityID = id
+		}
+		return db.appendShared(datoms)
+	}
+
 	op := eavOp(datoms)
 	feed.Append(op, db.key)
 
-	sf, err := db.PutUserFeed(feed)
+	sf, err := db.putUserFeed(feed)
 	if err != nil {
 		return err
 	}
-	db.applyOp(op, fp)
 	db.announce(sf)
 	return nil
 }
 
 // Add adds a new entity to the db
 func (db *DB) Add(src interface{}) (string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.add(src)
+}
+
+func (db *DB) add(src interface{}) (string, error) {
 	u, err := uuid.NewV4()
 	if err != nil {
 		return "", err
 	}
 	eid := u.String()
-	feed, err := db.UserFeed()
-	if err != nil {
+	id := db.fp + ":" + eid
+	err = db.put(id, src)
+	return id, err
+}
+
+// Upsert puts src over the entity in the user's feed whose field is value,
+// or adds it as a new entity if there isn't one
+// field has to be declared with an ave or unique index
+func (db *DB) Upsert(kind string, field string, value interface{}, src interface{}) (string, error) {
+	return db.UpsertContext(context.Background(), kind, field, value, src)
+}
+
+// UpsertContext is Upsert, but gives up if ctx is done before the op is written
+func (db *DB) UpsertContext(ctx context.Context, kind string, field string, value interface{}, src interface{}) (string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := ctx.Err(); err != nil {
 		return "", err
 	}
-	fp, err := feed.Fingerprint()
+
+	attr := kind + "/" + field
+	if !db.attrIndex(attr).hasAVE() {
+		return "", errors.New("Can't upsert on " + attr + " without an ave index")
+	}
+	i, err := db.store.Prefix(NewKey("ave", attr, fmt.Sprintf("%v", value)).Prefix())
 	if err != nil {
 		return "", err
 	}
-	id := fp + ":" + eid
-	err = db.Put(id, src)
-	return id, err
+	for _, v, err := i.Next(); err == nil; _, v, err = i.Next() {
+		id := string(v)
+		if strings.HasPrefix(id, db.fp+":") {
+			return id, db.put(id, src)
+		}
+	}
+	return db.add(src)
 }
 
 // Remove an entity from the db by id
 func (db *DB) Remove(id string) error {
+	return db.RemoveContext(context.Background(), id)
+}
+
+// RemoveContext is Remove, but gives up if ctx is done before the op is written
+func (db *DB) RemoveContext(ctx context.Context, id string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	k := NewKey("eav", id)
-	i, err := db.store.Prefix(k.ToBytes())
+	i, err := db.store.Prefix(k.Prefix())
 	if err != nil {
 		return err
 	}
 
-	feed, err := db.UserFeed()
+	feed, err := db.userFeed()
 	if err != nil {
 		return err
 	}
@@ -492,14 +1234,14 @@ func (db *DB) Remove(id string) error {
 
 	var datoms []Datom
 	parts := strings.Split(id, ":")
-	if parts[0] != fp {
+	shared := db.isShared(id)
+	if parts[0] != fp && !(shared && db.canWrite(id, fp)) {
 		return errors.New("Can't delete something not in your feed")
 	}
 	eid := parts[1]
 
 	for k, v, err := i.Next(); err == nil; k, v, err = i.Next() {
-		components := strings.Split(string(k), "/")
-		attr := components[2] + "/" + components[3] // eav/feed:entity/kind/attr/value
+		attr := ParseKey(k).Components()[2] // eav/feed:entity/kind%2Fattr
 
 		d := Datom{
 			FeedID:    fp,
@@ -511,21 +1253,28 @@ func (db *DB) Remove(id string) error {
 		datoms = append(datoms, d)
 	}
 
+	if shared {
+		for i := range datoms {
+			datoms[i].EntityID = id
+		}
+		return db.appendShared(datoms)
+	}
+
 	op := eavOp(datoms)
 	feed.Append(op, db.key)
 
-	sf, err := db.PutUserFeed(feed)
+	sf, err := db.putUserFeed(feed)
 	if err != nil {
 		return err
 	}
 
-	db.applyOp(op, fp)
 	err = db.announce(sf)
 	return err
 }
 
+// announce is called with db.mu held
 func (db *DB) announce(f feed.SignedFeed) error {
-	self, err := db.GetSelf()
+	self, err := db.getSelf()
 	if err != nil {
 		return err
 	}
@@ -533,17 +1282,20 @@ func (db *DB) announce(f feed.SignedFeed) error {
 		// TODO, can we queue these and release them later?
 		return errors.New("No self Pub found")
 	}
-	pubs, err := db.GetPubs()
+	pubs, _, err := db.getPubs()
 	if err != nil {
 		return err
 	}
 
-	go feed.Announce(self, pubs, f)
+	go feed.Announce(self, pubs, f, db.key)
 	return nil
 }
 
 // Dump returns every key and value in the db
 func (db *DB) Dump() [][]byte {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
 	var out [][]byte
 
 	k := NewKey("")
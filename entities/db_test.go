@@ -0,0 +1,325 @@
+package entities
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/awans/mark/feed"
+)
+
+// Note is the kind most tests store
+type Note struct {
+	ID     string
+	FeedID string
+	Title  string
+	Stars  int
+	First  string
+	Last   string
+}
+
+// newTestDB is a db over a memStore with no user feed,
+// for tests that apply datoms to the indexes directly
+func newTestDB() *DB {
+	return NewDB(newMemStore(), "me", nil)
+}
+
+// applyEntity indexes an entity as if its feed had been applied
+// id is feed:entity, and attrs are keyed by attribute, eg "Note/Title"
+func applyEntity(db *DB, id string, kind string, attrs map[string]interface{}) {
+	parts := strings.SplitN(id, ":", 2)
+	fp, eid := parts[0], parts[1]
+	db.ensureSysKeys(eid, fp)
+	db.applyDatom(Datom{FeedID: fp, EntityID: eid, Attribute: "db/Kind", Value: kind, Added: true})
+	for attr, v := range attrs {
+		db.applyDatom(Datom{FeedID: fp, EntityID: eid, Attribute: attr, Value: v, Added: true})
+	}
+}
+
+// testKeyBits keeps key generation quick; test keys only sign test feeds
+const testKeyBits = 1024
+
+// testFeed is a feed written by someone other than the db's user
+type testFeed struct {
+	key *rsa.PrivateKey
+	f   *feed.Feed
+	fp  string
+}
+
+func newTestFeed(t *testing.T) *testFeed {
+	key, err := rsa.GenerateKey(rand.Reader, testKeyBits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := feed.New(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp, err := f.Fingerprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testFeed{key: key, f: f, fp: fp}
+}
+
+// fork returns a copy of the feed's first n ops, to be continued differently
+func (tf *testFeed) fork(n int) *testFeed {
+	ops := append([]feed.Op(nil), tf.f.Ops[:n]...)
+	return &testFeed{key: tf.key, f: &feed.Feed{Ops: ops}, fp: tf.fp}
+}
+
+func (tf *testFeed) append(t *testing.T, op feed.Op) *testFeed {
+	err := tf.f.Append(op, tf.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tf
+}
+
+func (tf *testFeed) signed(t *testing.T, db *DB) feed.SignedFeed {
+	sf, err := db.c.Encode(tf.f, tf.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sf
+}
+
+// newUserDB is a db over a memStore whose user has a feed to write to
+func newUserDB(t *testing.T) *DB {
+	tf := newTestFeed(t)
+	db := NewDB(newMemStore(), tf.fp, tf.key)
+	if err := db.OpenIndexes(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.PutUserFeed(tf.f); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// noteOp writes a note with a title
+func noteOp(eid string, title string) feed.Op {
+	return eavOp([]Datom{
+		{EntityID: eid, Attribute: "db/Kind", Value: "Note", Added: true},
+		{EntityID: eid, Attribute: "Note/Title", Value: title, Added: true},
+	})
+}
+
+func TestGetMulti(t *testing.T) {
+	cases := []struct {
+		name    string
+		ids     []string
+		missing []int
+	}{
+		{"adjacent", []string{"f:1", "f:10"}, nil},
+		{"out of order", []string{"g:1", "f:3", "f:1"}, nil},
+		{"skips entities in between", []string{"f:1", "f:3"}, nil},
+		{"id that prefixes another", []string{"f:10", "f:1"}, nil},
+		{"missing", []string{"f:1", "f:4", "g:2"}, []int{1, 2}},
+		{"missing before the first", []string{"a:1", "f:2"}, []int{0}},
+		{"duplicates", []string{"f:2", "f:2"}, nil},
+		{"none", nil, nil},
+	}
+
+	db := newTestDB()
+	for _, id := range []string{"f:1", "f:2", "f:3", "f:10", "g:1"} {
+		applyEntity(db, id, "Note", map[string]interface{}{"Note/Title": id})
+	}
+
+	for _, c := range cases {
+		// the second time round everything that was found is cached
+		for _, pass := range []string{"scanned", "cached"} {
+			var notes []Note
+			err := db.GetMulti(c.ids, &notes)
+
+			missing := make(map[int]bool)
+			for _, i := range c.missing {
+				missing[i] = true
+			}
+			if len(c.missing) == 0 && err != nil {
+				t.Errorf("%s, %s: %v", c.name, pass, err)
+				continue
+			}
+			errs, _ := err.(MultiError)
+			if len(c.missing) > 0 && len(errs) != len(c.ids) {
+				t.Errorf("%s, %s: got %v, want a MultiError for each id", c.name, pass, err)
+				continue
+			}
+			if len(notes) != len(c.ids) {
+				t.Errorf("%s, %s: got %d notes for %d ids", c.name, pass, len(notes), len(c.ids))
+				continue
+			}
+
+			for i, id := range c.ids {
+				want := Note{ID: id, FeedID: strings.Split(id, ":")[0], Title: id}
+				if missing[i] {
+					want = Note{}
+					if errs[i] != ErrNoSuchEntity {
+						t.Errorf("%s, %s: got %v for %s, want ErrNoSuchEntity", c.name, pass, errs[i], id)
+					}
+				} else if errs != nil && errs[i] != nil {
+					t.Errorf("%s, %s: got %v for %s", c.name, pass, errs[i], id)
+				}
+				if notes[i] != want {
+					t.Errorf("%s, %s: got %+v for %s, want %+v", c.name, pass, notes[i], id, want)
+				}
+			}
+		}
+	}
+}
+
+func TestMultiErrorMessage(t *testing.T) {
+	cases := []struct {
+		errs MultiError
+		want string
+	}{
+		{MultiError{nil, ErrNoSuchEntity}, "No such entity"},
+		{MultiError{ErrNoSuchEntity, nil, ErrNoSuchEntity}, "No such entity (and 1 other errors)"},
+	}
+	for _, c := range cases {
+		if got := c.errs.Error(); got != c.want {
+			t.Errorf("got %q, want %q", got, c.want)
+		}
+	}
+}
+
+func TestAddAndUpdatePub(t *testing.T) {
+	db := newTestDB()
+	if err := db.PutPub(&feed.Pub{URL: "http://a", Failures: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a pub we know isn't replaced by one we're told about
+	if err := db.AddPub(&feed.Pub{URL: "http://a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdatePub("http://a", func(p *feed.Pub) { p.Failures++ }); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := db.GetPub("http://a"); err != nil || p.Failures != 4 {
+		t.Errorf("got %+v, %v", p, err)
+	}
+
+	if err := db.UpdatePub("http://b", func(p *feed.Pub) { t.Error("updated a pub we don't know") }); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddPub(&feed.Pub{URL: "http://b"}); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := db.GetPub("http://b"); err != nil || p == nil {
+		t.Errorf("got %+v, %v", p, err)
+	}
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	db := newUserDB(t)
+	const writers, each = 8, 5
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < each; i++ {
+				if _, err := db.Add(&Note{Title: "a"}); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// every write made it into one unbroken feed
+	sf, err := db.GetFeed(db.fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sf.Verify(); err != nil {
+		t.Error(err)
+	}
+	if want := writers*each + 1; len(sf) != want {
+		t.Errorf("the feed has %d ops, want %d", len(sf), want)
+	}
+	var notes []Note
+	if err := db.GetAll(&notes); err != nil || len(notes) != writers*each {
+		t.Errorf("got %d notes, %v", len(notes), err)
+	}
+}
+
+func TestReadsDuringRebuild(t *testing.T) {
+	tf := newTestFeed(t)
+	const count = 20
+	for i := 0; i < count; i++ {
+		tf.append(t, noteOp(fmt.Sprintf("%d", i), "a"))
+	}
+	db := newTestDB()
+	if err := db.PutFeed(tf.signed(t, db)); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			if err := db.RebuildIndexes(); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	// readers see the indexes before or after a rebuild, never partway through one
+	for reading := true; reading; {
+		select {
+		case <-done:
+			reading = false
+		default:
+		}
+		var notes []Note
+		if err := db.GetAll(&notes); err != nil || len(notes) != count {
+			t.Fatalf("got %d notes, %v", len(notes), err)
+		}
+	}
+}
+
+func TestDropFeed(t *testing.T) {
+	db := newUserDB(t)
+	a, b := newTestFeed(t), newTestFeed(t)
+	a.append(t, noteOp("1", "a")).append(t, noteOp("2", "a"))
+	b.append(t, noteOp("1", "b"))
+	for _, tf := range []*testFeed{a, b} {
+		if err := db.PutFeed(tf.signed(t, db)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.DropFeed(a.fp); err != nil {
+		t.Fatal(err)
+	}
+	if sf, _ := db.GetFeed(a.fp); len(sf) != 0 {
+		t.Errorf("the feed is still there, with %d ops", len(sf))
+	}
+	var notes []Note
+	if err := db.GetAll(&notes); err != nil || len(notes) != 1 || notes[0].FeedID != b.fp {
+		t.Errorf("got %+v, %v", notes, err)
+	}
+	for _, index := range append(indexes, "fts") {
+		i, err := db.store.Prefix(NewKey(index).Prefix())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, _, err := i.Next(); err == nil; k, _, err = i.Next() {
+			if strings.Contains(string(k), a.fp) {
+				t.Errorf("%s is left", k)
+			}
+		}
+	}
+
+	if err := db.DropFeed(a.fp); err == nil {
+		t.Error("dropped a feed that's gone")
+	}
+	if err := db.DropFeed(db.fp); err == nil {
+		t.Error("dropped the user's own feed")
+	}
+}
@@ -20,12 +20,16 @@ func (i *filterIterator) Next() (string, error) {
 }
 
 // match checks the predicate for a given entity id
-// Currently assumes the predicate is =
+// Currently assumes the predicate is = or !=
 func (i *filterIterator) match(eid string) bool {
 	k := NewKey("eav", eid, i.f.Attribute)
 	v, err := i.db.store.Get(k.ToBytes())
 	if err != nil {
 		return false
 	}
-	return string(v) == i.f.Value.(string) // TODO :(
+	eq := string(v) == i.f.Value.(string) // TODO :(
+	if i.f.Predicate == Ne {
+		return !eq
+	}
+	return eq
 }
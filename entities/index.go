@@ -0,0 +1,77 @@
+package entities
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Index says which indexes an attribute is kept in
+// Every attribute is always in the eav index, since that's how entities are read
+type Index int
+
+// Indexes an attribute can be declared with
+const (
+	IndexNone Index = 0
+	IndexAEV  Index = 1 << iota
+	IndexAVE
+	IndexVAE
+	IndexFullText
+	// IndexUnique keeps an ave entry, and Put refuses to give two entities
+	// in the same feed the same value
+	IndexUnique
+
+	// IndexDefault is used for attributes that haven't been declared
+	IndexDefault = IndexAEV | IndexAVE | IndexVAE
+)
+
+// DeclareIndex sets which indexes a field of kind is kept in
+// It has to be called before the indexes are rebuilt
+func (db *DB) DeclareIndex(kind string, field string, idx Index) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.attrIndexes[kind+"/"+field] = idx
+}
+
+// attrIndex returns the indexes for an attribute
+func (db *DB) attrIndex(attr string) Index {
+	if idx, ok := db.attrIndexes[attr]; ok {
+		return idx
+	}
+	return IndexDefault
+}
+
+// hasAVE says whether attr can be looked up by value
+func (idx Index) hasAVE() bool {
+	return idx&(IndexAVE|IndexUnique) != 0
+}
+
+// checkUnique makes sure no other entity in d's feed has d's value for a unique attribute
+func (db *DB) checkUnique(d Datom) error {
+	if db.attrIndex(d.Attribute)&IndexUnique == 0 {
+		return nil
+	}
+	id := d.FeedID + ":" + d.EntityID
+	i, err := db.store.Prefix(NewKey("ave", d.Attribute, fmt.Sprintf("%v", d.Value)).Prefix())
+	if err != nil {
+		return err
+	}
+	for _, v, err := i.Next(); err == nil; _, v, err = i.Next() {
+		other := string(v)
+		if other != id && strings.HasPrefix(other, d.FeedID+":") {
+			return fmt.Errorf("%s %v is already used by %s", d.Attribute, d.Value, other)
+		}
+	}
+	return nil
+}
+
+// sysIndexes are the declarations for the db's own attributes
+// db/Kind drives every query; the others are read through eav
+func sysIndexes() map[string]Index {
+	return map[string]Index{
+		"db/Kind":         IndexAVE,
+		"db/ID":           IndexNone,
+		"db/FeedID":       IndexAVE,
+		schemaVersionAttr: IndexNone,
+		writersAttr:       IndexNone,
+	}
+}
@@ -0,0 +1,130 @@
+package entities
+
+import (
+	"reflect"
+	"testing"
+)
+
+// indexedIn lists the indexes that have an entry for attr
+func indexedIn(t *testing.T, db *DB, attr string) []string {
+	var in []string
+	for _, index := range append(indexes, "fts") {
+		i, err := db.store.Prefix(NewKey(index).Prefix())
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := false
+		for k, _, err := i.Next(); err == nil && !found; k, _, err = i.Next() {
+			for _, c := range ParseKey(k).Components() {
+				found = found || c == attr
+			}
+		}
+		if found {
+			in = append(in, index)
+		}
+	}
+	return in
+}
+
+func TestDeclaredIndexes(t *testing.T) {
+	cases := []struct {
+		name     string
+		idx      *Index
+		expected []string
+	}{
+		{"undeclared", nil, []string{"eav", "aev", "ave", "vae"}},
+		{"none", indexPtr(IndexNone), []string{"eav"}},
+		{"ave", indexPtr(IndexAVE), []string{"eav", "ave"}},
+		{"aev and vae", indexPtr(IndexAEV | IndexVAE), []string{"eav", "aev", "vae"}},
+		{"full text", indexPtr(IndexFullText), []string{"eav", "fts"}},
+		{"unique", indexPtr(IndexUnique), []string{"eav", "ave"}},
+	}
+
+	for _, c := range cases {
+		db := newTestDB()
+		if c.idx != nil {
+			db.DeclareIndex("Note", "Title", *c.idx)
+		}
+		applyEntity(db, "f:1", "Note", map[string]interface{}{"Note/Title": "hello"})
+		if got := indexedIn(t, db, "Note/Title"); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("%s: indexed in %v, want %v", c.name, got, c.expected)
+		}
+	}
+}
+
+func indexPtr(idx Index) *Index {
+	return &idx
+}
+
+func TestUniqueIndex(t *testing.T) {
+	db := newUserDB(t)
+	db.DeclareIndex("Note", "Title", IndexUnique)
+
+	first, err := db.Add(&Note{Title: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// someone else's note doesn't take the title from us
+	applyEntity(db, "g:1", "Note", map[string]interface{}{"Note/Title": "b"})
+
+	cases := []struct {
+		name string
+		put  func() error
+		ok   bool
+	}{
+		{"another note with the title", func() error { _, err := db.Add(&Note{Title: "a"}); return err }, false},
+		{"the same note again", func() error { return db.Put(first, &Note{Title: "a"}) }, true},
+		{"a title only another feed has", func() error { _, err := db.Add(&Note{Title: "b"}); return err }, true},
+		{"a title after its note is removed", func() error {
+			// with no self pub the announcement fails, but the removal is written
+			db.Remove(first)
+			_, err := db.Add(&Note{Title: "a"})
+			return err
+		}, true},
+	}
+	for _, c := range cases {
+		if err := c.put(); (err == nil) != c.ok {
+			t.Errorf("%s: got %v", c.name, err)
+		}
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	db := newUserDB(t)
+	db.DeclareIndex("Note", "Title", IndexUnique)
+	db.DeclareIndex("Note", "Last", IndexNone)
+
+	first, err := db.Upsert("Note", "Title", "a", &Note{Title: "a", Stars: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := db.Upsert("Note", "Title", "a", &Note{Title: "a", Stars: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != first {
+		t.Errorf("upserting again made %s, want %s", again, first)
+	}
+	var n Note
+	if err := db.Get(first, &n); err != nil || n.Stars != 2 {
+		t.Errorf("got %+v, %v", n, err)
+	}
+
+	// another feed's note isn't ours to update
+	applyEntity(db, "g:1", "Note", map[string]interface{}{"Note/Title": "b"})
+	other, err := db.Upsert("Note", "Title", "b", &Note{Title: "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == "g:1" || other == first {
+		t.Errorf("upserting b put %s", other)
+	}
+	n = Note{}
+	if err := db.Get(other, &n); err != nil || n.Title != "b" || n.FeedID != db.fp {
+		t.Errorf("got %+v, %v", n, err)
+	}
+
+	if _, err := db.Upsert("Note", "Last", "x", &Note{Last: "x"}); err == nil {
+		t.Error("upserted on a field with no ave index")
+	}
+}
@@ -10,6 +10,15 @@ type Key struct {
 // Separator is the path separator for store keys
 var Separator = []byte("/")
 
+// escape is the escape character for components
+// Components are percent-encoded so they can contain the separator
+var escape = []byte("%")
+
+var (
+	escapedEscape    = []byte("%25")
+	escapedSeparator = []byte("%2F")
+)
+
 // NewKey returns a key from a path
 func NewKey(components ...string) *Key {
 	var path [][]byte
@@ -19,7 +28,45 @@ func NewKey(components ...string) *Key {
 	return &Key{path: path}
 }
 
+// ParseKey reverses ToBytes
+func ParseKey(b []byte) *Key {
+	var path [][]byte
+	for _, c := range bytes.Split(b, Separator) {
+		path = append(path, unescapeComponent(c))
+	}
+	return &Key{path: path}
+}
+
 // ToBytes renders the key to bytes
 func (k *Key) ToBytes() []byte {
-	return bytes.Join(k.path, Separator)
+	var escaped [][]byte
+	for _, c := range k.path {
+		escaped = append(escaped, escapeComponent(c))
+	}
+	return bytes.Join(escaped, Separator)
+}
+
+// Prefix renders the key to bytes that only match keys below it,
+// so that a scan over kind "Book" doesn't include "Bookmark"
+func (k *Key) Prefix() []byte {
+	return append(k.ToBytes(), Separator...)
+}
+
+// Components returns each component of the key
+func (k *Key) Components() []string {
+	var out []string
+	for _, c := range k.path {
+		out = append(out, string(c))
+	}
+	return out
+}
+
+func escapeComponent(c []byte) []byte {
+	c = bytes.Replace(c, escape, escapedEscape, -1)
+	return bytes.Replace(c, Separator, escapedSeparator, -1)
+}
+
+func unescapeComponent(c []byte) []byte {
+	c = bytes.Replace(c, escapedSeparator, Separator, -1)
+	return bytes.Replace(c, escapedEscape, escape, -1)
 }
@@ -0,0 +1,111 @@
+package entities
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestParseKeyRoundTrips(t *testing.T) {
+	cases := []struct {
+		name       string
+		components []string
+		bytes      string
+	}{
+		{"plain", []string{"eav", "f:1", "Note/Title"}, "eav/f:1/Note%2FTitle"},
+		{"separator", []string{"ave", "Bookmark/URL", "http://a.com/b"}, "ave/Bookmark%2FURL/http:%2F%2Fa.com%2Fb"},
+		{"escape", []string{"ave", "Note/Title", "100%"}, "ave/Note%2FTitle/100%25"},
+		{"escaped separator", []string{"ave", "Note/Title", "a%2Fb"}, "ave/Note%2FTitle/a%252Fb"},
+		{"escaped escape", []string{"ave", "Note/Title", "%25"}, "ave/Note%2FTitle/%2525"},
+		{"escape then separator", []string{"ave", "Note/Title", "%/"}, "ave/Note%2FTitle/%25%2F"},
+		{"empty component", []string{"ave", "Note/Title", "", "f:1"}, "ave/Note%2FTitle//f:1"},
+		{"fts", []string{"fts", "Note/Title", "café", "f:1"}, "fts/Note%2FTitle/café/f:1"},
+	}
+
+	for _, c := range cases {
+		b := NewKey(c.components...).ToBytes()
+		if string(b) != c.bytes {
+			t.Errorf("%s: got %q, want %q", c.name, b, c.bytes)
+		}
+		got := ParseKey(b).Components()
+		if !reflect.DeepEqual(got, c.components) {
+			t.Errorf("%s: got %q back, want %q", c.name, got, c.components)
+		}
+	}
+}
+
+func TestKeyPrefix(t *testing.T) {
+	cases := []struct {
+		prefix *Key
+		key    *Key
+		match  bool
+	}{
+		{NewKey("ave", "db/Kind", "Book"), NewKey("ave", "db/Kind", "Book", "f:1"), true},
+		{NewKey("ave", "db/Kind", "Book"), NewKey("ave", "db/Kind", "Bookmark", "f:1"), false},
+		{NewKey("ave", "Note/Title", "a"), NewKey("ave", "Note/Title", "a/b", "f:1"), false},
+		{NewKey("ave", "Note/Title", "a/b"), NewKey("ave", "Note/Title", "a/b", "f:1"), true},
+	}
+
+	for _, c := range cases {
+		match := bytes.HasPrefix(c.key.ToBytes(), c.prefix.Prefix())
+		if match != c.match {
+			t.Errorf("%q has prefix %q: got %v, want %v", c.key.ToBytes(), c.prefix.Prefix(), match, c.match)
+		}
+	}
+}
+
+// oldKey is how keys were written before components were escaped
+func oldKey(k []byte) []byte {
+	return bytes.Join(ParseKey(k).path, Separator)
+}
+
+func TestMigrateKeys(t *testing.T) {
+	tf := newTestFeed(t)
+	tf.append(t, noteOp("1", "a/b%2F")).append(t, noteOp("2", "100%"))
+	db := newTestDB()
+	if err := db.PutFeed(tf.signed(t, db)); err != nil {
+		t.Fatal(err)
+	}
+
+	// put the indexes back the way an older version wrote them
+	var old [][]byte
+	for _, index := range []string{"eav", "aev", "ave", "vae"} {
+		i, err := db.store.Prefix(NewKey(index).Prefix())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v, err := i.Next(); err == nil; k, v, err = i.Next() {
+			if !bytes.Equal(oldKey(k), k) {
+				old = append(old, oldKey(k))
+			}
+			db.store.Delete(k)
+			db.store.Set(oldKey(k), v)
+		}
+	}
+	if len(old) == 0 {
+		t.Fatal("no key changes format")
+	}
+	db.store.Delete(keyFormatKey())
+
+	if err := db.MigrateKeys(); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range old {
+		if v, _ := db.store.Get(k); v != nil {
+			t.Errorf("%q is still there", k)
+		}
+	}
+	if v, err := db.store.Get(keyFormatKey()); err != nil || string(v) != "1" {
+		t.Errorf("the key format is %q, %v", v, err)
+	}
+	if titles := noteTitles(t, db); !reflect.DeepEqual(titles, map[string]string{tf.fp + ":1": "a/b%2F", tf.fp + ":2": "100%"}) {
+		t.Errorf("got %v", titles)
+	}
+	for _, title := range []string{"a/b%2F", "100%"} {
+		var notes []Note
+		err := db.NewQuery("Note").Filter("Title =", title).GetAll(&notes)
+		if err != nil || len(notes) != 1 || notes[0].Title != title {
+			t.Errorf("%q: got %v, %v", title, notes, err)
+		}
+	}
+}
@@ -14,7 +14,7 @@ func newKindIterator(db *DB, kind string) queryIterator {
 
 func (i *kindIterator) init() error {
 	prefix := NewKey("ave", "db/Kind", i.kind)
-	iter, err := i.db.store.Prefix(prefix.ToBytes())
+	iter, err := i.db.store.Prefix(prefix.Prefix())
 	i.iter = iter
 	return err
 }
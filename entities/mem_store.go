@@ -0,0 +1,90 @@
+package entities
+
+import (
+	"bytes"
+	"io"
+	"sort"
+)
+
+// memStore is an in-memory Store, used to replay feeds without touching disk
+type memStore struct {
+	m map[string][]byte
+}
+
+func newMemStore() *memStore {
+	return &memStore{m: make(map[string][]byte)}
+}
+
+// Close implements Store
+func (s *memStore) Close() error {
+	return nil
+}
+
+// Get implements Store
+// Like KvStore, a missing key is a nil value rather than an error
+func (s *memStore) Get(key []byte) ([]byte, error) {
+	return s.m[string(key)], nil
+}
+
+// Set implements Store
+func (s *memStore) Set(key []byte, val []byte) error {
+	s.m[string(key)] = val
+	return nil
+}
+
+// Delete implements Store
+func (s *memStore) Delete(key []byte) error {
+	delete(s.m, string(key))
+	return nil
+}
+
+// Update implements Store
+func (s *memStore) Update(fn func(Store) error) error {
+	return fn(s)
+}
+
+type memIterator struct {
+	s    *memStore
+	keys []string
+}
+
+// Prefix implements Store
+// It snapshots the matching keys, so it's safe to delete while iterating
+func (s *memStore) Prefix(key []byte) (Iterator, error) {
+	var keys []string
+	for k := range s.m {
+		if bytes.HasPrefix([]byte(k), key) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return &memIterator{s: s, keys: keys}, nil
+}
+
+// Range implements Store
+func (s *memStore) Range(start []byte, end []byte, reverse bool) (Iterator, error) {
+	var keys []string
+	for k := range s.m {
+		if inRange([]byte(k), start, end) {
+			keys = append(keys, k)
+		}
+	}
+	if reverse {
+		sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+	} else {
+		sort.Strings(keys)
+	}
+	return &memIterator{s: s, keys: keys}, nil
+}
+
+// Next implements Iterator
+func (i *memIterator) Next() ([]byte, []byte, error) {
+	for len(i.keys) > 0 {
+		k := i.keys[0]
+		i.keys = i.keys[1:]
+		if v, ok := i.s.m[k]; ok {
+			return []byte(k), v, nil
+		}
+	}
+	return nil, nil, io.EOF
+}
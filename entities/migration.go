@@ -0,0 +1,120 @@
+package entities
+
+import (
+	"sort"
+	"strconv"
+)
+
+// schemaVersionAttr records which schema version an entity was written with
+const schemaVersionAttr = "db/SchemaVersion"
+
+// Migration rewrites an entity's attributes from the previous schema version
+// attrs is keyed by field name, eg "Title" rather than "Bookmark/Title"
+type Migration func(attrs map[string]string) error
+
+type migration struct {
+	version int
+	m       Migration
+}
+
+type migrations []migration
+
+func (a migrations) Len() int           { return len(a) }
+func (a migrations) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a migrations) Less(i, j int) bool { return a[i].version < a[j].version }
+
+// RegisterMigration tells the db how to read entities of kind written before version
+// Migrations run at read time, so feeds are never rewritten
+// Registering the same kind and version again replaces the earlier migration
+func (db *DB) RegisterMigration(kind string, version int, m Migration) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	ms := db.migrations[kind]
+	replaced := false
+	for i := range ms {
+		if ms[i].version == version {
+			ms[i].m = m
+			replaced = true
+		}
+	}
+	if !replaced {
+		ms = append(ms, migration{version: version, m: m})
+		sort.Sort(ms)
+	}
+	db.migrations[kind] = ms
+	// entities already read were hydrated without this migration
+	db.clearCaches()
+}
+
+// schemaVersion returns the version new entities of kind are written with
+func (db *DB) schemaVersion(kind string) int {
+	ms := db.migrations[kind]
+	if len(ms) == 0 {
+		return 0
+	}
+	return ms[len(ms)-1].version
+}
+
+// migrate applies every migration newer than the version the entity was written with
+func (db *DB) migrate(kind string, attrs map[string]string, sys map[string]string) error {
+	version := 0
+	if v, ok := sys[schemaVersionAttr]; ok {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		version = i
+	}
+	for _, m := range db.migrations[kind] {
+		if m.version <= version {
+			continue
+		}
+		err := m.m(attrs)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RenameAttribute moves a value from one attribute to another
+func RenameAttribute(from, to string) Migration {
+	return func(attrs map[string]string) error {
+		if v, ok := attrs[from]; ok {
+			if _, exists := attrs[to]; !exists {
+				attrs[to] = v
+			}
+			delete(attrs, from)
+		}
+		return nil
+	}
+}
+
+// DefaultValue sets an attribute that older entities didn't have
+func DefaultValue(attr, value string) Migration {
+	return func(attrs map[string]string) error {
+		if _, ok := attrs[attr]; !ok {
+			attrs[attr] = value
+		}
+		return nil
+	}
+}
+
+// SplitAttribute replaces one attribute with the attributes returned by split
+func SplitAttribute(from string, split func(string) (map[string]string, error)) Migration {
+	return func(attrs map[string]string) error {
+		v, ok := attrs[from]
+		if !ok {
+			return nil
+		}
+		parts, err := split(v)
+		if err != nil {
+			return err
+		}
+		delete(attrs, from)
+		for k, pv := range parts {
+			attrs[k] = pv
+		}
+		return nil
+	}
+}
@@ -0,0 +1,117 @@
+package entities
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func splitName(v string) (map[string]string, error) {
+	parts := strings.SplitN(v, " ", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("Bad name: " + v)
+	}
+	return map[string]string{"First": parts[0], "Last": parts[1]}, nil
+}
+
+func TestMigrationsApplyOnGet(t *testing.T) {
+	cases := []struct {
+		name       string
+		attrs      map[string]interface{}
+		migrations map[int]Migration
+		want       Note
+	}{
+		{
+			name:       "rename",
+			attrs:      map[string]interface{}{"Note/Heading": "hello"},
+			migrations: map[int]Migration{1: RenameAttribute("Heading", "Title")},
+			want:       Note{Title: "hello"},
+		},
+		{
+			name:       "default",
+			attrs:      map[string]interface{}{"Note/Title": "hello"},
+			migrations: map[int]Migration{1: DefaultValue("Stars", "3")},
+			want:       Note{Title: "hello", Stars: 3},
+		},
+		{
+			name:       "default keeps a stored value",
+			attrs:      map[string]interface{}{"Note/Stars": 5},
+			migrations: map[int]Migration{1: DefaultValue("Stars", "3")},
+			want:       Note{Stars: 5},
+		},
+		{
+			name:       "split",
+			attrs:      map[string]interface{}{"Note/Name": "Ada Lovelace"},
+			migrations: map[int]Migration{1: SplitAttribute("Name", splitName)},
+			want:       Note{First: "Ada", Last: "Lovelace"},
+		},
+		{
+			name:  "chained in version order",
+			attrs: map[string]interface{}{"Note/Heading": "hello"},
+			migrations: map[int]Migration{
+				2: DefaultValue("Title", "untitled"),
+				1: RenameAttribute("Heading", "Title"),
+			},
+			want: Note{Title: "hello"},
+		},
+		{
+			name:  "written at the current version",
+			attrs: map[string]interface{}{"Note/Heading": "old", "Note/Title": "new", schemaVersionAttr: 1},
+			migrations: map[int]Migration{
+				1: RenameAttribute("Heading", "Title"),
+				2: DefaultValue("Stars", "1"),
+			},
+			want: Note{Title: "new", Stars: 1},
+		},
+	}
+
+	for _, c := range cases {
+		db := newTestDB()
+		for version, m := range c.migrations {
+			db.RegisterMigration("Note", version, m)
+		}
+		applyEntity(db, "f:1", "Note", c.attrs)
+
+		var got Note
+		err := db.Get("f:1", &got)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		c.want.ID, c.want.FeedID = "f:1", "f"
+		if got != c.want {
+			t.Errorf("%s: got %+v, want %+v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestRegisterMigrationReplaces(t *testing.T) {
+	db := newTestDB()
+	applyEntity(db, "f:1", "Note", map[string]interface{}{"Note/Title": "hello"})
+
+	var n Note
+	db.RegisterMigration("Note", 1, DefaultValue("Stars", "1"))
+	err := db.Get("f:1", &n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Stars != 1 {
+		t.Fatalf("got %d stars, want 1", n.Stars)
+	}
+
+	// the note is cached now, so this also checks registering drops it
+	db.RegisterMigration("Note", 1, DefaultValue("Stars", "2"))
+	err = db.Get("f:1", &n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Stars != 2 {
+		t.Errorf("got %d stars, want 2", n.Stars)
+	}
+	if len(db.migrations["Note"]) != 1 {
+		t.Errorf("got %d migrations, want 1", len(db.migrations["Note"]))
+	}
+	if v := db.schemaVersion("Note"); v != 1 {
+		t.Errorf("got schema version %d, want 1", v)
+	}
+}
@@ -1,11 +1,13 @@
 package entities
 
 import (
+	"context"
 	"io"
 	"sort"
 )
 
 type orderIterator struct {
+	ctx        context.Context
 	o          *order
 	inner      queryIterator
 	workingSet []string
@@ -23,8 +25,8 @@ func (a sorts) Len() int           { return len(a) }
 func (a sorts) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a sorts) Less(i, j int) bool { return a[i].val < a[j].val }
 
-func newOrderIterator(o *order, db *DB, inner queryIterator) *orderIterator {
-	i := orderIterator{o: o, db: db, inner: inner}
+func newOrderIterator(ctx context.Context, o *order, db *DB, inner queryIterator) *orderIterator {
+	i := orderIterator{ctx: ctx, o: o, db: db, inner: inner}
 	return &i
 }
 
@@ -32,7 +34,10 @@ func (i *orderIterator) Next() (string, error) {
 	if i.returned == 0 {
 		// TODO errs
 		i.fill()
-		i.sort()
+		err := i.sort()
+		if err != nil {
+			return "", err
+		}
 	}
 	if i.returned == len(i.workingSet) {
 		return "", io.EOF
@@ -51,6 +56,9 @@ func (i *orderIterator) fill() {
 func (i *orderIterator) sort() error {
 	var s sorts
 	for _, v := range i.workingSet {
+		if err := i.ctx.Err(); err != nil {
+			return err
+		}
 		k := NewKey("eav", v, i.o.Attribute)
 		val, err := i.db.store.Get(k.ToBytes())
 		if err != nil {
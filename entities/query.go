@@ -1,10 +1,15 @@
 package entities
 
-import "strings"
+import (
+	"context"
+	"fmt"
+	"strings"
+)
 
 // Predicates
 const (
 	Eq = "="
+	Ne = "!="
 )
 
 // Sort directions
@@ -29,6 +34,7 @@ type Query struct {
 	db      *DB
 	filters []filter
 	order   []order
+	search  *search
 	kind    string
 	limit   int
 	offset  int
@@ -47,6 +53,18 @@ func (q *Query) Filter(spec string, val interface{}) *Query {
 	return q
 }
 
+// Search restricts the query to entities matching every word of text,
+// ordered by relevance unless the query has its own Order
+// spec is a field name, or several separated by commas, eg "Title,Note"
+func (q *Query) Search(spec string, text string) *Query {
+	var attrs []string
+	for _, f := range strings.Split(spec, ",") {
+		attrs = append(attrs, q.kind+"/"+strings.TrimSpace(f))
+	}
+	q.search = &search{Attributes: attrs, Text: text}
+	return q
+}
+
 // Order adds a sort order to the query
 func (q *Query) Order(spec string) *Query {
 	direction := Ascending
@@ -72,15 +90,32 @@ func (q *Query) Offset(offset int) *Query {
 }
 
 // GetAll returns the results of the query
+// Small results are cached until a datom touches one of the query's attributes
 func (q *Query) GetAll(dst interface{}) error {
-	i := newKindIterator(q.db, q.kind)
+	return q.GetAllContext(context.Background(), dst)
+}
+
+// GetAllContext is GetAll, but gives up with ctx's error once ctx is done
+func (q *Query) GetAllContext(ctx context.Context, dst interface{}) error {
+	q.db.mu.RLock()
+	defer q.db.mu.RUnlock()
+
+	key := q.cacheKey()
+	if c, ok := q.db.queryCache.get(key); ok {
+		return q.db.getFound(c.(*cachedQuery).eids, dst)
+	}
+
+	i, ordered := q.source(ctx)
+	i = newContextIterator(ctx, i)
 
 	for _, f := range q.filters {
 		i = newFilterIterator(&f, q.db, i)
 	}
 
-	for _, o := range q.order {
-		i = newOrderIterator(&o, q.db, i)
+	if !ordered {
+		for _, o := range q.order {
+			i = newOrderIterator(ctx, &o, q.db, i)
+		}
 	}
 
 	if q.offset != -1 {
@@ -95,5 +130,56 @@ func (q *Query) GetAll(dst interface{}) error {
 	for eid, err := i.Next(); err == nil; eid, err = i.Next() {
 		eids = append(eids, eid)
 	}
-	return q.db.GetMulti(eids, dst)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(eids) <= maxCachedResults {
+		q.db.queryCache.add(key, &cachedQuery{kind: q.kind, deps: q.deps(), eids: eids})
+	}
+	return q.db.getFound(eids, dst)
+}
+
+// source picks the iterator the query starts from
+// A single order over a value-indexed attribute is read straight off the index,
+// so offset and limit stop the scan early; ordered says whether that happened
+// Otherwise an = filter on a value-indexed attribute bounds the scan to its matches
+func (q *Query) source(ctx context.Context) (i queryIterator, ordered bool) {
+	if q.search != nil {
+		return newSearchIterator(ctx, q.search, q.db, q.kind), false
+	}
+	if len(q.order) == 1 && q.db.attrIndex(q.order[0].Attribute).hasAVE() {
+		o := q.order[0]
+		return newOrderedIterator(q.db, q.kind, o.Attribute, o.Direction == Descending), true
+	}
+	for _, f := range q.filters {
+		if f.Predicate == Eq && q.db.attrIndex(f.Attribute).hasAVE() {
+			return newAVEIterator(q.db, q.kind, f.Attribute, f.Value, false), false
+		}
+	}
+	return newKindIterator(q.db, q.kind), false
+}
+
+func (q *Query) cacheKey() string {
+	var s search
+	if q.search != nil {
+		s = *q.search
+	}
+	return fmt.Sprintf("%s|%v|%v|%v|%d|%d", q.kind, q.filters, q.order, s, q.limit, q.offset)
+}
+
+// deps returns the attributes whose datoms can change the query's results
+func (q *Query) deps() map[string]bool {
+	deps := make(map[string]bool)
+	for _, f := range q.filters {
+		deps[f.Attribute] = true
+	}
+	for _, o := range q.order {
+		deps[o.Attribute] = true
+	}
+	if q.search != nil {
+		for _, attr := range q.search.Attributes {
+			deps[attr] = true
+		}
+	}
+	return deps
 }
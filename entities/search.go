@@ -0,0 +1,158 @@
+package entities
+
+import (
+	"context"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// search is a full text match over one or more attributes
+type search struct {
+	Attributes []string
+	Text       string
+}
+
+// tokenize lowercases s and splits it into words
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+func termFrequencies(s string) map[string]int {
+	tfs := make(map[string]int)
+	for _, t := range tokenize(s) {
+		tfs[t]++
+	}
+	return tfs
+}
+
+// applyFullText updates the fts index for a datom
+// It has to run before the eav index is updated, so it can clear out the old value's tokens
+func (db *DB) applyFullText(d Datom) {
+	id := d.FeedID + ":" + d.EntityID
+	old, err := db.store.Get(d.EAVKey())
+	if err == nil && old != nil {
+		for token := range termFrequencies(string(old)) {
+			db.store.Delete(NewKey("fts", d.Attribute, token, id).ToBytes())
+		}
+	}
+	if !d.Added {
+		return
+	}
+	s, ok := d.Value.(string)
+	if !ok {
+		return
+	}
+	for token, n := range termFrequencies(s) {
+		db.store.Set(NewKey("fts", d.Attribute, token, id).ToBytes(), []byte(strconv.Itoa(n)))
+	}
+}
+
+type searchIterator struct {
+	ctx      context.Context
+	s        *search
+	kind     string
+	db       *DB
+	ranked   []string
+	ran      bool
+	returned int
+}
+
+func newSearchIterator(ctx context.Context, s *search, db *DB, kind string) *searchIterator {
+	i := searchIterator{ctx: ctx, s: s, db: db, kind: kind}
+	return &i
+}
+
+type scorePair struct {
+	eid   string
+	score float64
+}
+type scores []scorePair
+
+func (a scores) Len() int      { return len(a) }
+func (a scores) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a scores) Less(i, j int) bool {
+	if a[i].score == a[j].score {
+		return a[i].eid < a[j].eid
+	}
+	return a[i].score > a[j].score
+}
+
+// Next returns matching entity ids, best match first
+func (i *searchIterator) Next() (string, error) {
+	if !i.ran {
+		i.ran = true
+		err := i.rank()
+		if err != nil {
+			return "", err
+		}
+	}
+	if i.returned == len(i.ranked) {
+		return "", io.EOF
+	}
+	i.returned++
+	return i.ranked[i.returned-1], nil
+}
+
+// rank finds entities containing every token in any of the attributes,
+// and scores them by tf-idf
+func (i *searchIterator) rank() error {
+	tokens := termFrequencies(i.s.Text)
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	total := 0
+	ki, err := i.db.store.Prefix(NewKey("ave", "db/Kind", i.kind).Prefix())
+	if err != nil {
+		return err
+	}
+	for _, _, err := ki.Next(); err == nil; _, _, err = ki.Next() {
+		total++
+	}
+
+	score := make(map[string]float64)
+	matched := make(map[string]int)
+	for token := range tokens {
+		if err := i.ctx.Err(); err != nil {
+			return err
+		}
+		tfs := make(map[string]int)
+		for _, attr := range i.s.Attributes {
+			p, err := i.db.store.Prefix(NewKey("fts", attr, token).Prefix())
+			if err != nil {
+				return err
+			}
+			for k, v, err := p.Next(); err == nil; k, v, err = p.Next() {
+				tf, err := strconv.Atoi(string(v))
+				if err != nil {
+					continue
+				}
+				eid := ParseKey(k).Components()[3]
+				tfs[eid] += tf
+			}
+		}
+		idf := math.Log(1 + float64(total)/float64(len(tfs)))
+		for eid, tf := range tfs {
+			score[eid] += float64(tf) * idf
+			matched[eid]++
+		}
+	}
+
+	var s scores
+	for eid, n := range matched {
+		if n == len(tokens) {
+			s = append(s, scorePair{eid: eid, score: score[eid]})
+		}
+	}
+	sort.Sort(s)
+	for _, p := range s {
+		i.ranked = append(i.ranked, p.eid)
+	}
+	return nil
+}
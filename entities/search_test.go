@@ -0,0 +1,69 @@
+package entities
+
+import (
+	"reflect"
+	"testing"
+)
+
+func newSearchDB() *DB {
+	db := newTestDB()
+	db.DeclareIndex("Note", "Title", IndexFullText)
+	titles := map[string]string{
+		"f:1": "Go go gophers",
+		"f:2": "Learning Go",
+		"f:3": "Rust book",
+		"f:4": "go/ast and Go's parser",
+	}
+	for id, title := range titles {
+		applyEntity(db, id, "Note", map[string]interface{}{"Note/Title": title})
+	}
+	return db
+}
+
+func searchIDs(t *testing.T, db *DB, text string) []string {
+	var notes []Note
+	err := db.NewQuery("Note").Search("Title", text).GetAll(&notes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, n := range notes {
+		ids = append(ids, n.ID)
+	}
+	return ids
+}
+
+func TestSearch(t *testing.T) {
+	cases := []struct {
+		text string
+		want []string
+	}{
+		// more occurrences rank higher, and ties go by id
+		{"go", []string{"f:1", "f:4", "f:2"}},
+		{"GO gophers", []string{"f:1"}},
+		{"parser, go!", []string{"f:4"}},
+		{"rust", []string{"f:3"}},
+		{"python", nil},
+		{"", nil},
+	}
+
+	db := newSearchDB()
+	for _, c := range cases {
+		got := searchIDs(t, db, c.text)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%q: got %v, want %v", c.text, got, c.want)
+		}
+	}
+}
+
+func TestSearchAfterUpdate(t *testing.T) {
+	db := newSearchDB()
+	applyEntity(db, "f:3", "Note", map[string]interface{}{"Note/Title": "Go book"})
+
+	if got := searchIDs(t, db, "rust"); got != nil {
+		t.Errorf("rust: got %v after the title changed", got)
+	}
+	if got := searchIDs(t, db, "book"); !reflect.DeepEqual(got, []string{"f:3"}) {
+		t.Errorf("book: got %v, want [f:3]", got)
+	}
+}
@@ -0,0 +1,274 @@
+package entities
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/awans/mark/feed"
+)
+
+// writersAttr lists the feeds, besides the owner's, that may write to a shared entity
+const writersAttr = "db/Writers"
+
+// sharedOpName is the op for writes to shared entities
+const sharedOpName = "shared-eav"
+
+// sharedOp is the body of a shared-eav op
+// Its datoms carry the full feed:entity id, since the entity can be in another feed,
+// and Time is part of the signed op, so it orders writes across feeds
+// Time is whatever the writer's clock said, so we only trust it as far as ours:
+// a write dated more than maxClockSkew ahead is taken to be that far ahead,
+// so a writer can't make a write that beats every later one. Writers are
+// otherwise trusted with their clocks, since backdating only loses them writes
+type sharedOp struct {
+	Time   int64
+	Datoms []Datom
+}
+
+// maxClockSkew is how far ahead of our clock a shared write can be dated
+const maxClockSkew = 5 * time.Minute
+
+// ConvertSharedOp implements Converter
+func ConvertSharedOp(bytes []byte) (interface{}, error) {
+	var s sharedOp
+	err := json.Unmarshal(bytes, &s)
+	return &s, err
+}
+
+// write is one feed's latest write to an attribute of a shared entity
+type write struct {
+	Time  int64  `json:"t"`
+	Value string `json:"v"`
+	Added bool   `json:"a"`
+}
+
+// writeKey is where writer's latest write to attr of id is kept
+// lww/feed:entity/attr/writer
+func writeKey(id string, attr string, writer string) []byte {
+	return NewKey("lww", id, attr, writer).ToBytes()
+}
+
+func splitID(id string) (string, string) {
+	parts := strings.SplitN(id, ":", 2)
+	if len(parts) < 2 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// Share lets the writers' feeds write to an entity in the user's feed
+// The current attributes are written again so they take part in conflict resolution
+// Sharing again replaces the list, and sharing with no one stops further writes
+func (db *DB) Share(id string, writers []string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	owner, _ := splitID(id)
+	if owner != db.fp {
+		return errors.New("Can't share something not in your feed")
+	}
+	i, err := db.store.Prefix(NewKey("eav", id).Prefix())
+	if err != nil {
+		return err
+	}
+	var datoms []Datom
+	for k, v, err := i.Next(); err == nil; k, v, err = i.Next() {
+		attr := ParseKey(k).Components()[2]
+		if attr == "db/ID" || attr == "db/FeedID" || attr == writersAttr {
+			continue
+		}
+		datoms = append(datoms, Datom{EntityID: id, Attribute: attr, Value: string(v), Added: true})
+	}
+	if len(datoms) == 0 {
+		return ErrNoSuchEntity
+	}
+	datoms = append(datoms, Datom{EntityID: id, Attribute: writersAttr, Value: strings.Join(writers, ","), Added: true})
+	return db.appendShared(datoms)
+}
+
+// Writers returns the feeds that may write to an entity, not counting its owner
+func (db *DB) Writers(id string) ([]string, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	v, err := db.store.Get(NewKey("eav", id, writersAttr).ToBytes())
+	if err != nil || len(v) == 0 {
+		return nil, err
+	}
+	return strings.Split(string(v), ","), nil
+}
+
+// appendShared signs datoms into a shared-eav op on the user's feed
+func (db *DB) appendShared(datoms []Datom) error {
+	return db.appendOp(feed.Op{Op: sharedOpName, Body: &sharedOp{Time: time.Now().UnixNano(), Datoms: datoms}})
+}
+
+// isShared says whether an entity's attributes are resolved from writes,
+// which is only once its owner has shared it
+func (db *DB) isShared(id string) bool {
+	owner, _ := splitID(id)
+	v, err := db.store.Get(writeKey(id, writersAttr, owner))
+	return err == nil && v != nil
+}
+
+// canWrite says whether fp may write to the entity id
+func (db *DB) canWrite(id string, fp string) bool {
+	owner, _ := splitID(id)
+	if owner == fp {
+		return true
+	}
+	v, err := db.store.Get(NewKey("eav", id, writersAttr).ToBytes())
+	if err != nil {
+		return false
+	}
+	for _, w := range strings.Split(string(v), ",") {
+		if w == fp {
+			return true
+		}
+	}
+	return false
+}
+
+func (db *DB) applySharedOp(s *sharedOp, fp string) {
+	t := s.Time
+	if limit := time.Now().Add(maxClockSkew).UnixNano(); t > limit {
+		t = limit
+	}
+	for _, d := range s.Datoms {
+		db.recordWrite(d.EntityID, d.Attribute, fp, write{Time: t, Value: fmt.Sprintf("%v", d.Value), Added: d.Added})
+	}
+}
+
+// recordWrite keeps the latest write from each feed, then re-resolves what it affects
+// Every write is kept, authorized or not, so the result doesn't depend on
+// the order feeds are applied in, eg a writer's feed before the owner shares;
+// but only authorized writes to shared entities take part in resolving
+func (db *DB) recordWrite(id string, attr string, writer string, w write) {
+	k := writeKey(id, attr, writer)
+	old, err := db.store.Get(k)
+	if err == nil && old != nil {
+		var ow write
+		if json.Unmarshal(old, &ow) == nil && ow.Time > w.Time {
+			return
+		}
+	}
+	bytes, err := json.Marshal(w)
+	if err != nil {
+		return
+	}
+	db.store.Set(k, bytes)
+
+	owner, _ := splitID(id)
+	if writer != owner && !db.canWrite(id, writer) {
+		return
+	}
+	if attr == writersAttr {
+		db.resolveEntity(id)
+	} else {
+		db.resolve(id, attr)
+	}
+}
+
+// resolveEntity re-resolves the writers and then every other attribute,
+// since changing the writers can change any of them
+func (db *DB) resolveEntity(id string) {
+	db.resolve(id, writersAttr)
+
+	prefix := NewKey("lww", id).Prefix()
+	i, err := db.store.Range(prefix, prefixEnd(prefix), false)
+	if err != nil {
+		return
+	}
+	attrs := make(map[string]bool)
+	for k, _, err := i.Next(); err == nil; k, _, err = i.Next() {
+		attrs[ParseKey(k).Components()[2]] = true
+	}
+	for attr := range attrs {
+		if attr != writersAttr {
+			db.resolve(id, attr)
+		}
+	}
+}
+
+// resolve sets attr of id to the latest authorized write, the greater feed id
+// breaking ties; only the owner decides the writers
+// An entity that isn't shared is left as its owner's feed has it
+func (db *DB) resolve(id string, attr string) {
+	if !db.isShared(id) {
+		return
+	}
+	owner, eid := splitID(id)
+	prefix := NewKey("lww", id, attr).Prefix()
+	i, err := db.store.Range(prefix, prefixEnd(prefix), false)
+	if err != nil {
+		return
+	}
+	var best *write
+	var bestBy string
+	for k, v, err := i.Next(); err == nil; k, v, err = i.Next() {
+		writer := ParseKey(k).Components()[3]
+		if writer != owner && (attr == writersAttr || !db.canWrite(id, writer)) {
+			continue
+		}
+		var w write
+		if json.Unmarshal(v, &w) != nil {
+			continue
+		}
+		if best == nil || w.Time > best.Time || (w.Time == best.Time && writer > bestBy) {
+			best, bestBy = &w, writer
+		}
+	}
+
+	d := Datom{FeedID: owner, EntityID: eid, Attribute: attr}
+	cur, err := db.store.Get(d.EAVKey())
+	if err != nil {
+		return
+	}
+	if best != nil && best.Added {
+		if cur != nil && string(cur) == best.Value {
+			return
+		}
+		d.Value, d.Added = best.Value, true
+		db.applyDatom(d)
+		db.ensureSysKeys(eid, owner)
+		return
+	}
+	if cur != nil {
+		d.Value, d.Added = string(cur), false
+		db.applyDatom(d)
+	}
+}
+
+// dropWrites forgets the writes of a dropped feed, and the writes to its entities
+func (db *DB) dropWrites(fp string) error {
+	p, err := db.store.Prefix(NewKey("lww").Prefix())
+	if err != nil {
+		return err
+	}
+	var drop [][]byte
+	affected := make(map[[2]string]bool)
+	for k, _, err := p.Next(); err == nil; k, _, err = p.Next() {
+		components := ParseKey(k).Components()
+		id, attr, writer := components[1], components[2], components[3]
+		if strings.HasPrefix(id, fp+":") {
+			drop = append(drop, k)
+		} else if writer == fp {
+			drop = append(drop, k)
+			affected[[2]string{id, attr}] = true
+		}
+	}
+	for _, k := range drop {
+		db.store.Delete(k)
+	}
+	for a := range affected {
+		if a[1] == writersAttr {
+			db.resolveEntity(a[0])
+		} else {
+			db.resolve(a[0], a[1])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package entities
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the shared note is owned by o, and w1, w2 and s can try to write to it
+const sharedNote = "o:1"
+
+type sharedWrite struct {
+	fp    string
+	time  int64
+	attr  string
+	value string
+	added bool
+}
+
+func (w sharedWrite) apply(db *DB) {
+	d := Datom{EntityID: sharedNote, Attribute: w.attr, Value: w.value, Added: w.added}
+	db.applySharedOp(&sharedOp{Time: w.time, Datoms: []Datom{d}}, w.fp)
+}
+
+func title(fp string, time int64, value string) sharedWrite {
+	return sharedWrite{fp, time, "Note/Title", value, true}
+}
+
+func writers(fp string, time int64, fps ...string) sharedWrite {
+	return sharedWrite{fp, time, writersAttr, strings.Join(fps, ","), true}
+}
+
+func TestSharedResolve(t *testing.T) {
+	cases := []struct {
+		name   string
+		writes []sharedWrite
+		title  string
+		shared bool
+	}{
+		{
+			name:   "latest write wins",
+			writes: []sharedWrite{title("o", 1, "orig"), writers("o", 1, "w1", "w2"), title("w2", 2, "two"), title("w1", 3, "one")},
+			title:  "one",
+			shared: true,
+		},
+		{
+			name:   "an earlier write loses whenever it's applied",
+			writes: []sharedWrite{title("o", 1, "orig"), writers("o", 1, "w1", "w2"), title("w1", 3, "one"), title("w2", 2, "two")},
+			title:  "one",
+			shared: true,
+		},
+		{
+			name:   "ties go to the greater feed id",
+			writes: []sharedWrite{title("o", 1, "orig"), writers("o", 1, "w1", "w2"), title("w2", 2, "two"), title("w1", 2, "one")},
+			title:  "two",
+			shared: true,
+		},
+		{
+			name:   "writes from before the share count once it's shared",
+			writes: []sharedWrite{title("w1", 3, "one"), title("o", 1, "orig"), writers("o", 1, "w1")},
+			title:  "one",
+			shared: true,
+		},
+		{
+			name:   "removal",
+			writes: []sharedWrite{title("o", 1, "orig"), writers("o", 1, "w1"), {"w1", 2, "Note/Title", "orig", false}},
+			title:  "",
+			shared: true,
+		},
+		{
+			name:   "strangers are ignored",
+			writes: []sharedWrite{title("o", 1, "orig"), writers("o", 1, "w1"), title("s", 5, "spam")},
+			title:  "orig",
+			shared: true,
+		},
+		{
+			name:   "strangers can't share",
+			writes: []sharedWrite{writers("s", 5, "s"), title("s", 5, "spam")},
+			title:  "plain",
+		},
+		{
+			name:   "writers can't add writers",
+			writes: []sharedWrite{title("o", 1, "orig"), writers("o", 1, "w1"), writers("w1", 2, "w1", "s"), title("s", 3, "spam")},
+			title:  "orig",
+			shared: true,
+		},
+		{
+			name:   "unsharing drops a writer's writes",
+			writes: []sharedWrite{title("o", 1, "orig"), writers("o", 1, "w1", "w2"), title("w2", 3, "two"), writers("o", 4, "w1")},
+			title:  "orig",
+			shared: true,
+		},
+		{
+			name:   "and sharing again brings them back",
+			writes: []sharedWrite{title("o", 1, "orig"), writers("o", 1, "w1", "w2"), title("w2", 3, "two"), writers("o", 4, "w1"), writers("o", 5, "w2")},
+			title:  "two",
+			shared: true,
+		},
+	}
+
+	for _, c := range cases {
+		db := newTestDB()
+		// the owner's feed had the note before anything was shared
+		applyEntity(db, sharedNote, "Note", map[string]interface{}{"Note/Title": "plain"})
+		for _, w := range c.writes {
+			w.apply(db)
+		}
+
+		var n Note
+		err := db.Get(sharedNote, &n)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if n.Title != c.title {
+			t.Errorf("%s: got title %q, want %q", c.name, n.Title, c.title)
+		}
+		if got := db.isShared(sharedNote); got != c.shared {
+			t.Errorf("%s: shared is %v, want %v", c.name, got, c.shared)
+		}
+	}
+}
+
+func TestFutureWritesAreClamped(t *testing.T) {
+	db := newTestDB()
+	applyEntity(db, sharedNote, "Note", map[string]interface{}{"Note/Title": "plain"})
+	writers("o", 1, "w1", "w2").apply(db)
+
+	now := time.Now()
+	soon := now.Add(maxClockSkew / 2).UnixNano()
+	title("w1", soon, "soon").apply(db)
+	title("w2", now.Add(100*365*24*time.Hour).UnixNano(), "future").apply(db)
+
+	written := func(writer string) int64 {
+		v, err := db.store.Get(writeKey(sharedNote, "Note/Title", writer))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var w write
+		if err := json.Unmarshal(v, &w); err != nil {
+			t.Fatal(err)
+		}
+		return w.Time
+	}
+	if got := written("w1"); got != soon {
+		t.Errorf("a write within the skew was dated %d, want %d", got, soon)
+	}
+	if got, limit := written("w2"), time.Now().Add(maxClockSkew).UnixNano(); got > limit {
+		t.Errorf("a write from the future was dated %v past the limit", time.Duration(got-limit))
+	}
+}
@@ -15,6 +15,13 @@ type Store interface {
 	Set([]byte, []byte) error
 	Delete([]byte) error
 	Prefix([]byte) (Iterator, error)
+	// Range iterates over keys >= start and < end, backwards if reverse is set
+	// A nil start or end leaves that side unbounded
+	Range(start []byte, end []byte, reverse bool) (Iterator, error)
+	// Update runs fn with a Store that commits its writes in batches,
+	// which for bulk writes is much faster than committing each one
+	// fn's error doesn't undo what it wrote, so it's as if they were written one at a time
+	Update(fn func(Store) error) error
 }
 
 // Iterator iterates through keys, returning io.EOF when it's exhausted
@@ -22,6 +29,30 @@ type Iterator interface {
 	Next() ([]byte, []byte, error)
 }
 
+// prefixEnd returns the first key after every key starting with prefix,
+// or nil if there isn't one
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// inRange says whether k is within [start, end)
+func inRange(k []byte, start []byte, end []byte) bool {
+	if start != nil && bytes.Compare(k, start) < 0 {
+		return false
+	}
+	if end != nil && bytes.Compare(k, end) >= 0 {
+		return false
+	}
+	return true
+}
+
 // KvStore is an implementation of Store based on cznic kv
 type KvStore struct {
 	db *kv.DB
@@ -31,7 +62,7 @@ var opts = &kv.Options{Compare: nil}
 
 // CreateStore makes a db file for a KvStore
 func CreateStore(dirname string) (store Store, err error) {
-	filename := path.Join(dirname, "db")
+	filename := path.Join(dirname, kvFilename)
 	db, err := kv.Create(filename, opts)
 	if err != nil {
 		return nil, err
@@ -42,7 +73,7 @@ func CreateStore(dirname string) (store Store, err error) {
 
 // OpenStore opens an existing KvStore
 func OpenStore(dirname string) (store Store, err error) {
-	filename := path.Join(dirname, "db")
+	filename := path.Join(dirname, kvFilename)
 
 	db, err := kv.Open(filename, opts)
 	if err != nil {
@@ -72,6 +103,21 @@ func (kv KvStore) Delete(key []byte) error {
 	return kv.db.Delete(key)
 }
 
+// Update implements Store
+// kv nests transactions, so fn can update again
+func (kv KvStore) Update(fn func(Store) error) error {
+	err := kv.db.BeginTransaction()
+	if err != nil {
+		return err
+	}
+	ferr := fn(kv)
+	err = kv.db.Commit()
+	if err != nil {
+		return err
+	}
+	return ferr
+}
+
 type kvIterator struct {
 	e      *kv.Enumerator
 	prefix []byte
@@ -86,6 +132,83 @@ func (kv KvStore) Prefix(key []byte) (Iterator, error) {
 	return kvIterator{e: e, prefix: key}, nil
 }
 
+// Range implements Store
+func (kv KvStore) Range(start []byte, end []byte, reverse bool) (Iterator, error) {
+	if !reverse {
+		e, _, err := kv.db.Seek(start)
+		if err != nil {
+			return nil, err
+		}
+		return &kvRangeIterator{e: e, start: start, end: end}, nil
+	}
+
+	i := &kvRangeIterator{start: start, end: end, reverse: true}
+	if end != nil {
+		e, _, err := kv.db.Seek(end)
+		if err != nil {
+			return nil, err
+		}
+		k, v, err := e.Prev()
+		if err == nil {
+			// Seek lands on the first key >= end, which we don't want
+			i.e = e
+			if bytes.Compare(k, end) < 0 {
+				i.k, i.v = k, v
+			}
+			return i, nil
+		}
+		if err != io.EOF {
+			return nil, err
+		}
+		// end is past the last key
+	}
+	e, err := kv.db.SeekLast()
+	if err == io.EOF {
+		return emptyIterator{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	i.e = e
+	return i, nil
+}
+
+type kvRangeIterator struct {
+	e          *kv.Enumerator
+	start, end []byte
+	reverse    bool
+	// k and v are an item that was read ahead
+	k, v []byte
+}
+
+// Next implements Iterator
+func (i *kvRangeIterator) Next() ([]byte, []byte, error) {
+	var k, v []byte
+	var err error
+	if i.k != nil {
+		k, v = i.k, i.v
+		i.k, i.v = nil, nil
+	} else if i.reverse {
+		k, v, err = i.e.Prev()
+	} else {
+		k, v, err = i.e.Next()
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if !inRange(k, i.start, i.end) {
+		return nil, nil, io.EOF
+	}
+	return k, v, nil
+}
+
+type emptyIterator struct{}
+
+// Next implements Iterator
+func (emptyIterator) Next() ([]byte, []byte, error) {
+	return nil, nil, io.EOF
+}
+
 // Next implements Iterator
 func (i kvIterator) Next() ([]byte, []byte, error) {
 	k, v, err := i.e.Next()
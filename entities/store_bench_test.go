@@ -0,0 +1,128 @@
+package entities
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+// benchBookmarks is how many bookmarks are in the store before each read benchmark
+const benchBookmarks = 2000
+
+// benchDatoms returns the datoms a bookmark puts in the store
+func benchDatoms(feedID string, n int) []Datom {
+	eid := fmt.Sprintf("%08d", n)
+	attrs := map[string]interface{}{
+		"db/Kind":            "Bookmark",
+		"db/FeedID":          feedID,
+		"db/ID":              feedID + ":" + eid,
+		"Bookmark/CreatedAt": 1500000000 + n,
+		"Bookmark/Title":     fmt.Sprintf("Bookmark number %d about golang and databases", n),
+		"Bookmark/URL":       fmt.Sprintf("https://example.com/posts/%d/some-slug", n),
+		"Bookmark/Note":      "A short note, the kind people leave on a bookmark so they remember why they saved it.",
+	}
+	var ds []Datom
+	for attr, v := range attrs {
+		ds = append(ds, Datom{FeedID: feedID, EntityID: eid, Attribute: attr, Value: v, Added: true})
+	}
+	return ds
+}
+
+func benchPut(s Store, feedID string, n int) {
+	for _, d := range benchDatoms(feedID, n) {
+		v := []byte(fmt.Sprintf("%v", d.Value))
+		id := []byte(d.FeedID + ":" + d.EntityID)
+		s.Set(d.EAVKey(), v)
+		s.Set(d.AEVKey(), v)
+		s.Set(d.AVEKey(), id)
+		s.Set(d.VAEKey(), id)
+	}
+}
+
+func drain(i Iterator, limit int) int {
+	n := 0
+	for _, _, err := i.Next(); err == nil && n != limit; _, _, err = i.Next() {
+		n++
+	}
+	return n
+}
+
+var benchStoreCases = []struct {
+	name string
+	run  func(b *testing.B, s Store)
+}{
+	{"put", func(b *testing.B, s Store) {
+		for i := 0; i < b.N; i++ {
+			benchPut(s, "benchfeed", benchBookmarks+i)
+		}
+	}},
+	{"put batched", func(b *testing.B, s Store) {
+		s.Update(func(s Store) error {
+			for i := 0; i < b.N; i++ {
+				benchPut(s, "benchfeed", benchBookmarks+i)
+			}
+			return nil
+		})
+	}},
+	{"get", func(b *testing.B, s Store) {
+		for i := 0; i < b.N; i++ {
+			id := fmt.Sprintf("benchfeed:%08d", rand.Intn(benchBookmarks))
+			it, _ := s.Prefix(NewKey("eav", id).Prefix())
+			drain(it, -1)
+		}
+	}},
+	{"scan kind", func(b *testing.B, s Store) {
+		for i := 0; i < b.N; i++ {
+			it, _ := s.Prefix(NewKey("ave", "db/Kind", "Bookmark").Prefix())
+			drain(it, -1)
+		}
+	}},
+	{"newest 20", func(b *testing.B, s Store) {
+		prefix := NewKey("ave", "Bookmark/CreatedAt").Prefix()
+		for i := 0; i < b.N; i++ {
+			it, _ := s.Range(prefix, prefixEnd(prefix), true)
+			drain(it, 20)
+		}
+	}},
+}
+
+// benchStore makes a store with benchBookmarks bookmarks in it
+func benchStore(b *testing.B, backend string) (Store, func()) {
+	dir, err := ioutil.TempDir("", "mark-bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	s, err := CreateBackendStore(backend, dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		b.Fatal(err)
+	}
+	s.Update(func(s Store) error {
+		for n := 0; n < benchBookmarks; n++ {
+			benchPut(s, "benchfeed", n)
+		}
+		return nil
+	})
+	return s, func() {
+		s.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// BenchmarkStore runs bookmark workloads against each backend
+func BenchmarkStore(b *testing.B) {
+	for _, backend := range []string{KvBackend, BoltBackend} {
+		b.Run(backend, func(b *testing.B) {
+			for _, c := range benchStoreCases {
+				// b.Run calls its func more than once, so each case fills its store just once
+				s, cleanup := benchStore(b, backend)
+				b.Run(c.name, func(b *testing.B) {
+					c.run(b, s)
+				})
+				cleanup()
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package entities
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestRange(t *testing.T) {
+	keys := []string{"a", "b/1", "b/2", "b/3", "c"}
+	cases := []struct {
+		name       string
+		start, end string
+		reverse    bool
+		want       []string
+	}{
+		{"everything", "", "", false, keys},
+		{"everything backwards", "", "", true, []string{"c", "b/3", "b/2", "b/1", "a"}},
+		{"bounded", "b/1", "b/3", false, []string{"b/1", "b/2"}},
+		{"bounded backwards", "b/1", "b/3", true, []string{"b/2", "b/1"}},
+		{"bounds between keys", "b/15", "b/25", false, []string{"b/2"}},
+		{"bounds between keys backwards", "b/15", "b/25", true, []string{"b/2"}},
+		{"end between keys backwards", "", "b/25", true, []string{"b/2", "b/1", "a"}},
+		{"end past the last key backwards", "b/2", "z", true, []string{"c", "b/3", "b/2"}},
+		{"end before the first key backwards", "", "0", true, nil},
+		{"start past the last key", "x", "", false, nil},
+		{"start past the last key backwards", "x", "", true, nil},
+		{"empty", "b/2", "b/2", true, nil},
+		{"prefix backwards", "b/", string(prefixEnd([]byte("b/"))), true, []string{"b/3", "b/2", "b/1"}},
+	}
+
+	stores, cleanup := testStores(t)
+	defer cleanup()
+	for name, s := range stores {
+		// nothing to iterate yet
+		i, err := s.Range(nil, nil, true)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got := drainKeys(t, i); got != nil {
+			t.Errorf("%s: got %v from an empty store", name, got)
+		}
+
+		for _, k := range keys {
+			s.Set([]byte(k), []byte(k))
+		}
+		for _, c := range cases {
+			var start, end []byte
+			if c.start != "" {
+				start = []byte(c.start)
+			}
+			if c.end != "" {
+				end = []byte(c.end)
+			}
+			i, err := s.Range(start, end, c.reverse)
+			if err != nil {
+				t.Fatalf("%s, %s: %v", name, c.name, err)
+			}
+			if got := drainKeys(t, i); !reflect.DeepEqual(got, c.want) {
+				t.Errorf("%s, %s: got %v, want %v", name, c.name, got, c.want)
+			}
+		}
+	}
+}
+
+func TestRangeBackwardsAcrossBatches(t *testing.T) {
+	stores, cleanup := testStores(t)
+	defer cleanup()
+
+	var want []string
+	for n := 2*boltBatchSize + 1; n >= 0; n-- {
+		want = append(want, fmt.Sprintf("k%04d", n))
+	}
+	for name, s := range stores {
+		for _, k := range want {
+			s.Set([]byte(k), nil)
+		}
+		s.Set([]byte("l"), nil)
+
+		i, err := s.Range([]byte("k"), []byte("l"), true)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got := drainKeys(t, i); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %d keys, want %d newest first", name, len(got), len(want))
+		}
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	cases := []struct {
+		prefix, want []byte
+	}{
+		{[]byte("ave/"), []byte("ave0")},
+		{[]byte("a\xff"), []byte("b")},
+		{[]byte("\xff\xff"), nil},
+		{nil, nil},
+	}
+	for _, c := range cases {
+		if got := prefixEnd(c.prefix); string(got) != string(c.want) || (got == nil) != (c.want == nil) {
+			t.Errorf("%q: got %q, want %q", c.prefix, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package feed
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// BundleFormat is the version of the bundle file format
+const BundleFormat = 1
+
+// Bundle carries feeds and pubs in a single file, between nodes that can't reach each other
+// The feeds verify themselves, and Sum catches a file damaged on the way
+type Bundle struct {
+	Format  int          `json:"format"`
+	Created int64        `json:"created"`
+	Feeds   []SignedFeed `json:"feeds"`
+	Pubs    []Pub        `json:"pubs"`
+	Sum     string       `json:"sum"`
+}
+
+// NewBundle makes a bundle of feeds and pubs
+// Only the pubs' urls are kept; what this node has seen of them means nothing to another
+func NewBundle(feeds []SignedFeed, pubs []Pub) *Bundle {
+	b := Bundle{Format: BundleFormat, Created: time.Now().Unix(), Feeds: feeds}
+	for _, p := range pubs {
+		b.Pubs = append(b.Pubs, Pub{URL: p.URL})
+	}
+	b.Sum = b.sum()
+	return &b
+}
+
+// sum hashes the feeds and pubs
+func (b *Bundle) sum() string {
+	sha := sha256.New()
+	json.NewEncoder(sha).Encode(b.Feeds)
+	json.NewEncoder(sha).Encode(b.Pubs)
+	return base64.RawURLEncoding.EncodeToString(sha.Sum(nil))
+}
+
+// Write writes the bundle as gzipped json
+func (b *Bundle) Write(w io.Writer) error {
+	z := gzip.NewWriter(w)
+	err := json.NewEncoder(z).Encode(b)
+	if err != nil {
+		return err
+	}
+	return z.Close()
+}
+
+// ReadBundle reads a bundle made by Write, and checks it arrived intact
+// It doesn't verify the feeds; they're verified as they're merged
+func ReadBundle(r io.Reader) (*Bundle, error) {
+	z, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer z.Close()
+	var b Bundle
+	err = json.NewDecoder(z).Decode(&b)
+	if err != nil {
+		return nil, err
+	}
+	if b.Format != BundleFormat {
+		return nil, fmt.Errorf("Unknown bundle format %d", b.Format)
+	}
+	if b.Sum != b.sum() {
+		return nil, errors.New("Bundle is damaged: checksum mismatch")
+	}
+	return &b, nil
+}
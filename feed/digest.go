@@ -0,0 +1,98 @@
+package feed
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DigestBuckets is how many buckets a HeadsDigest splits feeds into
+const DigestBuckets = 32
+
+// HeadsDigest is a compact summary of a pub's heads
+// Feeds are split into buckets by a hash of their id, and each bucket is
+// summarized by a hash of its heads, so two nodes can tell which buckets
+// differ and only exchange the heads in those
+type HeadsDigest struct {
+	Buckets []string `json:"buckets"`
+}
+
+// bucketOf is the bucket a feed's head goes in
+func bucketOf(id string) int {
+	sha := sha256.Sum256([]byte(id))
+	return int(sha[0]) % DigestBuckets
+}
+
+// Digest summarizes heads
+func Digest(heads []Head) HeadsDigest {
+	byBucket := make([][]Head, DigestBuckets)
+	for _, h := range heads {
+		b := bucketOf(h.ID)
+		byBucket[b] = append(byBucket[b], h)
+	}
+	d := HeadsDigest{Buckets: make([]string, DigestBuckets)}
+	for b, hs := range byBucket {
+		sort.Slice(hs, func(i, j int) bool { return hs[i].ID < hs[j].ID })
+		sha := sha256.New()
+		for _, h := range hs {
+			fmt.Fprintf(sha, "%s:%d\n", h.ID, h.Len)
+		}
+		d.Buckets[b] = base64.RawURLEncoding.EncodeToString(sha.Sum(nil)[:12])
+	}
+	return d
+}
+
+// Differs returns the buckets where d and other disagree
+// Digests with a different number of buckets disagree everywhere
+func (d HeadsDigest) Differs(other HeadsDigest) []int {
+	var differ []int
+	for b := range d.Buckets {
+		if len(other.Buckets) != len(d.Buckets) || d.Buckets[b] != other.Buckets[b] {
+			differ = append(differ, b)
+		}
+	}
+	return differ
+}
+
+// InBuckets returns the heads that fall in the given buckets
+func InBuckets(heads []Head, buckets []int) []Head {
+	in := make(map[int]bool)
+	for _, b := range buckets {
+		in[b] = true
+	}
+	var out []Head
+	for _, h := range heads {
+		if in[bucketOf(h.ID)] {
+			out = append(out, h)
+		}
+	}
+	return out
+}
+
+// FormatBuckets writes buckets as a query parameter, eg "1,5,9"
+func FormatBuckets(buckets []int) string {
+	s := make([]string, len(buckets))
+	for i, b := range buckets {
+		s[i] = strconv.Itoa(b)
+	}
+	return strings.Join(s, ",")
+}
+
+// ParseBuckets reads buckets written by FormatBuckets
+func ParseBuckets(s string) ([]int, error) {
+	var buckets []int
+	for _, part := range strings.Split(s, ",") {
+		b, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, err
+		}
+		if b < 0 || b >= DigestBuckets {
+			return nil, fmt.Errorf("No such bucket: %d", b)
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets, nil
+}
@@ -0,0 +1,81 @@
+package feed
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDigestDiffers(t *testing.T) {
+	heads := []Head{{"a", 1}, {"b", 2}, {"c", 3}}
+	cases := []struct {
+		name  string
+		other []Head
+		want  []int
+	}{
+		{"same", []Head{{"a", 1}, {"b", 2}, {"c", 3}}, nil},
+		{"same in another order", []Head{{"c", 3}, {"a", 1}, {"b", 2}}, nil},
+		{"longer feed", []Head{{"a", 1}, {"b", 5}, {"c", 3}}, []int{bucketOf("b")}},
+		{"extra feed", []Head{{"a", 1}, {"b", 2}, {"c", 3}, {"d", 1}}, []int{bucketOf("d")}},
+		{"missing feed", []Head{{"a", 1}, {"b", 2}}, []int{bucketOf("c")}},
+	}
+
+	d := Digest(heads)
+	for _, c := range cases {
+		got := d.Differs(Digest(c.other))
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestDigestWithOtherBucketCount(t *testing.T) {
+	d := Digest([]Head{{"a", 1}})
+	other := HeadsDigest{Buckets: d.Buckets[:DigestBuckets-1]}
+	if got := d.Differs(other); len(got) != DigestBuckets {
+		t.Errorf("got %d differing buckets, want all %d", len(got), DigestBuckets)
+	}
+}
+
+func TestInBuckets(t *testing.T) {
+	heads := []Head{{"a", 1}, {"b", 2}, {"c", 3}}
+	for _, h := range heads {
+		got := InBuckets(heads, []int{bucketOf(h.ID)})
+		found := false
+		for _, g := range got {
+			if bucketOf(g.ID) != bucketOf(h.ID) {
+				t.Errorf("%v isn't in bucket %d", g, bucketOf(h.ID))
+			}
+			found = found || g == h
+		}
+		if !found {
+			t.Errorf("%v wasn't in its own bucket", h)
+		}
+	}
+	if got := InBuckets(heads, nil); got != nil {
+		t.Errorf("got %v for no buckets", got)
+	}
+}
+
+func TestParseBuckets(t *testing.T) {
+	cases := []struct {
+		s    string
+		want []int
+		ok   bool
+	}{
+		{"0", []int{0}, true},
+		{"1,5,31", []int{1, 5, 31}, true},
+		{"", nil, false},
+		{"1,x", nil, false},
+		{"32", nil, false},
+		{"-1", nil, false},
+	}
+	for _, c := range cases {
+		got, err := ParseBuckets(c.s)
+		if (err == nil) != c.ok || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%q: got %v, %v", c.s, got, err)
+		}
+		if c.ok && FormatBuckets(got) != c.s {
+			t.Errorf("%q: formatted as %q", c.s, FormatBuckets(got))
+		}
+	}
+}
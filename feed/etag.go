@@ -0,0 +1,84 @@
+package feed
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ErrNotModified is returned by a conditional get when the pub's copy still matches
+var ErrNotModified = errors.New("Not modified")
+
+// ETag makes a strong entity tag from some bytes
+func ETag(b []byte) string {
+	sha := sha256.Sum256(b)
+	return `"` + base64.RawURLEncoding.EncodeToString(sha[:12]) + `"`
+}
+
+// FeedETag tags a feed by its length and last op, so any node
+// with the same copy of the feed computes the same tag
+func FeedETag(sf SignedFeed) string {
+	if len(sf) == 0 {
+		return `"0"`
+	}
+	sha := sha256.Sum256([]byte(sf[len(sf)-1]))
+	return fmt.Sprintf(`"%d-%s"`, len(sf), base64.RawURLEncoding.EncodeToString(sha[:12]))
+}
+
+// HeadsETag tags a list of heads by their ids and lengths, in any order
+func HeadsETag(heads []Head) string {
+	sorted := append([]Head{}, heads...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+	sha := sha256.New()
+	for _, h := range sorted {
+		fmt.Fprintf(sha, "%s:%d\n", h.ID, h.Len)
+	}
+	return `"` + base64.RawURLEncoding.EncodeToString(sha.Sum(nil)[:12]) + `"`
+}
+
+// etagCache remembers the last response to each url and its tag,
+// so an unchanged response can be answered with a 304
+type etagCache struct {
+	mu      sync.Mutex
+	entries map[string]etagEntry
+}
+
+// maxETagEntries bounds an etagCache, since heads can be asked for by any set of buckets
+const maxETagEntries = 1024
+
+type etagEntry struct {
+	etag string
+	body []byte
+}
+
+func newETagCache() *etagCache {
+	return &etagCache{entries: make(map[string]etagEntry)}
+}
+
+func (c *etagCache) get(u string) (etagEntry, bool) {
+	if c == nil {
+		return etagEntry{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[u]
+	return e, ok
+}
+
+func (c *etagCache) put(u string, e etagEntry) {
+	if c == nil || e.etag == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[u]; !ok && len(c.entries) >= maxETagEntries {
+		for k := range c.entries {
+			delete(c.entries, k)
+			break
+		}
+	}
+	c.entries[u] = e
+}
@@ -69,13 +69,20 @@ func contentHash(s string) string {
 
 // Decode turns a SignedFeed into a feed, verifying it along the way
 func (c *Coder) Decode(sf SignedFeed) (*Feed, error) {
+	return c.DecodeFrom(sf, 0)
+}
+
+// DecodeFrom decodes and verifies the ops of a SignedFeed from start onwards,
+// for when the ones before it have already been seen
+func (c *Coder) DecodeFrom(sf SignedFeed, start int) (*Feed, error) {
 	key, err := sf.CurrentKey()
 	if err != nil {
 		return nil, err
 	}
 
 	var f Feed
-	for i, s := range sf {
+	for i := start; i < len(sf); i++ {
+		s := sf[i]
 		opJws, err := jose.ParseSigned(s)
 		if err != nil {
 			return nil, err
@@ -93,12 +100,12 @@ func (c *Coder) Decode(sf SignedFeed) (*Feed, error) {
 		if i > 0 {
 			prev := sf[i-1]
 			if op.FeedHash != contentHash(prev) {
-				fmt.Printf("Verification failed for op %s\n", op)
+				fmt.Printf("Verification failed for op %v\n", op)
 			}
 		}
 
 		if op.OpNum != i {
-			fmt.Printf("OpNum bad for %s\n", op)
+			fmt.Printf("OpNum bad for %v\n", op)
 		}
 
 		op.DecodeBody(c.registry)
@@ -108,6 +115,47 @@ func (c *Coder) Decode(sf SignedFeed) (*Feed, error) {
 	return &f, nil
 }
 
+// Verify checks every signature in a SignedFeed and that its ops form a single chain
+// Unlike Decode, it fails on the first broken link
+func (sf SignedFeed) Verify() error {
+	return sf.VerifyFrom(0)
+}
+
+// VerifyFrom is Verify for the ops from start onwards,
+// for when the ones before it are already trusted
+func (sf SignedFeed) VerifyFrom(start int) error {
+	if len(sf) == 0 {
+		return errors.New("Empty feed")
+	}
+	key, err := sf.CurrentKey()
+	if err != nil {
+		return err
+	}
+	for i := start; i < len(sf); i++ {
+		s := sf[i]
+		opJws, err := jose.ParseSigned(s)
+		if err != nil {
+			return fmt.Errorf("op %d: %v", i, err)
+		}
+		opBytes, err := opJws.Verify(key)
+		if err != nil {
+			return fmt.Errorf("op %d: %v", i, err)
+		}
+		var op Op
+		err = json.Unmarshal(opBytes, &op)
+		if err != nil {
+			return fmt.Errorf("op %d: %v", i, err)
+		}
+		if op.OpNum != i {
+			return fmt.Errorf("op %d: has OpNum %d", i, op.OpNum)
+		}
+		if i > 0 && op.FeedHash != contentHash(sf[i-1]) {
+			return fmt.Errorf("op %d: FeedHash doesn't match op %d", i, i-1)
+		}
+	}
+	return nil
+}
+
 // Op is an arbitrary operation
 type Op struct {
 	Op       string
@@ -219,6 +267,28 @@ func DeclareKey(key *rsa.PublicKey) (*Op, error) {
 	return &Op{Op: "declare-key", Body: jwk}, nil
 }
 
+// PubDeclaration is the body of a declare-pub op
+type PubDeclaration struct {
+	URL string `json:"url"`
+}
+
+// DeclarePub returns an Op that says the feed's node can be reached at url
+// Announcements are only accepted from the url a feed last declared
+func DeclarePub(url string) *Op {
+	return &Op{Op: "declare-pub", Body: &PubDeclaration{URL: url}}
+}
+
+// Block is the body of a block op
+type Block struct {
+	Feed    string `json:"feed"`
+	Blocked bool   `json:"blocked"`
+}
+
+// DeclareBlock returns an Op that blocks a feed, or unblocks it if blocked is false
+func DeclareBlock(feedID string, blocked bool) *Op {
+	return &Op{Op: "block", Body: &Block{Feed: feedID, Blocked: blocked}}
+}
+
 // New bootstraps a feed
 func New(key *rsa.PrivateKey) (*Feed, error) {
 	var ops []Op
@@ -0,0 +1,122 @@
+package feed
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Scheduling bounds for polling a pub
+const (
+	minBackoff  = 30 * time.Second // after a first failure
+	maxBackoff  = 6 * time.Hour    // a dead pub is still probed this often
+	maxInterval = time.Hour        // between polls of a healthy pub that hasn't changed
+)
+
+// latencyWeight is how much a new request moves the average latency
+const latencyWeight = 0.2
+
+// PubHealth is what we've seen of a pub
+type PubHealth struct {
+	Requests  int64   `json:"requests"`
+	Successes int64   `json:"successes"`
+	Bytes     int64   `json:"bytes"`      // served to us
+	LatencyMs float64 `json:"latency_ms"` // moving average
+	Invalid   int     `json:"invalid"`    // times it served data that didn't verify
+	NextCheck int64   `json:"next_check"` // when it's next due
+}
+
+// SuccessRate is the fraction of requests that succeeded,
+// starting from an even chance for a pub we haven't tried
+func (h *PubHealth) SuccessRate() float64 {
+	return float64(h.Successes+1) / float64(h.Requests+2)
+}
+
+// Score ranks pubs, higher first: reliable and fast,
+// and much lower for any that have served invalid data
+func (p *Pub) Score() float64 {
+	h := p.Health
+	score := h.SuccessRate() / (1 + h.LatencyMs/1000)
+	return score / float64(1+10*h.Invalid)
+}
+
+// recordSuccess notes a successful poll or fetch
+func (p *Pub) recordSuccess(latency time.Duration, bytes int64) {
+	h := &p.Health
+	ms := float64(latency) / float64(time.Millisecond)
+	if h.Successes == 0 {
+		h.LatencyMs = ms
+	} else {
+		h.LatencyMs += latencyWeight * (ms - h.LatencyMs)
+	}
+	h.Requests++
+	h.Successes++
+	h.Bytes += bytes
+}
+
+// recordFailure notes a failed poll or fetch
+func (p *Pub) recordFailure(bytes int64) {
+	p.Health.Requests++
+	p.Health.Bytes += bytes
+}
+
+// recordInvalid notes that a pub served something that didn't verify
+func (p *Pub) recordInvalid() {
+	p.Health.Invalid++
+}
+
+// schedule sets when the pub is next due after a poll
+// Failures back off exponentially up to maxBackoff, so dead pubs are still re-probed;
+// otherwise a pub that hasn't changed in a while is polled less often, up to maxInterval
+func (p *Pub) schedule(now time.Time) {
+	var wait time.Duration
+	if p.Failures > 0 {
+		exp := math.Min(float64(p.Failures-1), math.Log2(float64(maxBackoff/minBackoff)))
+		wait = time.Duration(float64(minBackoff) * math.Pow(2, exp))
+	} else {
+		wait = time.Duration(p.LastChecked-p.LastUpdated) * time.Second
+		if wait > maxInterval {
+			wait = maxInterval
+		}
+	}
+	p.Health.NextCheck = now.Add(jitter(wait)).Unix()
+}
+
+// jitter spreads d over half to one and a half times itself,
+// so pubs that failed together aren't retried together
+func jitter(d time.Duration) time.Duration {
+	return time.Duration(float64(d) * (0.5 + rand.Float64()))
+}
+
+// Merge applies to p what changed from before to after,
+// eg a sync run's snapshot of a pub, to our latest record of it,
+// which may have changed in the meantime
+// Counts add up, times only move forward, and anything else takes after's value if it changed
+func (p *Pub) Merge(before, after Pub) {
+	if after.LastChecked > p.LastChecked {
+		p.LastChecked = after.LastChecked
+	}
+	if after.LastUpdated > p.LastUpdated {
+		p.LastUpdated = after.LastUpdated
+	}
+	if after.Failures == 0 && before.Failures > 0 {
+		p.Failures = 0
+	} else {
+		p.Failures += after.Failures - before.Failures
+	}
+	if after.Info != nil && (p.Info == nil || after.Info.Checked > p.Info.Checked) {
+		p.Info = after.Info
+	}
+
+	h, b, a := &p.Health, before.Health, after.Health
+	h.Requests += a.Requests - b.Requests
+	h.Successes += a.Successes - b.Successes
+	h.Bytes += a.Bytes - b.Bytes
+	h.Invalid += a.Invalid - b.Invalid
+	if a.LatencyMs != b.LatencyMs {
+		h.LatencyMs = a.LatencyMs
+	}
+	if a.NextCheck != b.NextCheck {
+		h.NextCheck = a.NextCheck
+	}
+}
@@ -0,0 +1,151 @@
+package feed
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleBounds(t *testing.T) {
+	cases := []struct {
+		name     string
+		failures int
+		// unchanged is how long the pub had gone without an update when it was checked
+		unchanged time.Duration
+		wait      time.Duration
+	}{
+		{"first failure", 1, 0, minBackoff},
+		{"second failure", 2, 0, 2 * minBackoff},
+		{"third failure", 3, 0, 4 * minBackoff},
+		{"many failures", 20, 0, maxBackoff},
+		{"dead", 1000, 0, maxBackoff},
+		{"just updated", 0, 0, 0},
+		{"quiet", 0, 10 * time.Minute, 10 * time.Minute},
+		{"quiet for days", 0, 72 * time.Hour, maxInterval},
+	}
+
+	now := time.Now()
+	for _, c := range cases {
+		p := Pub{
+			Failures:    c.failures,
+			LastChecked: now.Unix(),
+			LastUpdated: now.Add(-c.unchanged).Unix(),
+		}
+		earliest := now.Add(c.wait / 2).Unix()
+		latest := now.Add(c.wait * 3 / 2).Unix()
+		// jitter is random, so try it a few times
+		for i := 0; i < 100; i++ {
+			p.schedule(now)
+			if p.Health.NextCheck < earliest || p.Health.NextCheck > latest {
+				t.Errorf("%s: next check in %ds, want %ds to %ds", c.name,
+					p.Health.NextCheck-now.Unix(), earliest-now.Unix(), latest-now.Unix())
+				break
+			}
+		}
+	}
+}
+
+func TestJitter(t *testing.T) {
+	d := time.Minute
+	for i := 0; i < 1000; i++ {
+		j := jitter(d)
+		if j < d/2 || j >= d*3/2 {
+			t.Fatalf("got %v, want %v to %v", j, d/2, d*3/2)
+		}
+	}
+}
+
+func TestScoreOrder(t *testing.T) {
+	fast := Pub{Health: PubHealth{Requests: 10, Successes: 10, LatencyMs: 50}}
+	slow := Pub{Health: PubHealth{Requests: 10, Successes: 10, LatencyMs: 2000}}
+	untried := Pub{}
+	flaky := Pub{Health: PubHealth{Requests: 10, Successes: 2, LatencyMs: 50}}
+	invalid := Pub{Health: PubHealth{Requests: 10, Successes: 10, LatencyMs: 50, Invalid: 1}}
+
+	ranked := []struct {
+		name string
+		p    Pub
+	}{
+		{"fast", fast},
+		{"untried", untried},
+		{"slow", slow},
+		{"flaky", flaky},
+		{"invalid", invalid},
+	}
+	for i := 1; i < len(ranked); i++ {
+		a, b := ranked[i-1], ranked[i]
+		if a.p.Score() <= b.p.Score() {
+			t.Errorf("%s scored %f, not above %s's %f", a.name, a.p.Score(), b.name, b.p.Score())
+		}
+	}
+}
+
+func TestRecordHealth(t *testing.T) {
+	var p Pub
+	if r := p.Health.SuccessRate(); r != 0.5 {
+		t.Errorf("untried success rate is %f, want 0.5", r)
+	}
+
+	p.recordSuccess(100*time.Millisecond, 10)
+	p.recordSuccess(200*time.Millisecond, 10)
+	p.recordFailure(5)
+	p.recordInvalid()
+
+	want := PubHealth{Requests: 3, Successes: 2, Bytes: 25, LatencyMs: 120, Invalid: 1}
+	if p.Health != want {
+		t.Errorf("got %+v, want %+v", p.Health, want)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	before := Pub{URL: "a", LastChecked: 10, LastUpdated: 5, Failures: 2,
+		Health: PubHealth{Requests: 4, Successes: 2, Bytes: 100, LatencyMs: 50, NextCheck: 20}}
+
+	cases := []struct {
+		name string
+		// after is what the run made of before, and latest our record once it finished
+		after, latest, want Pub
+	}{
+		{
+			name:   "nothing happened meanwhile",
+			after:  Pub{URL: "a", LastChecked: 30, LastUpdated: 5, Failures: 0, Health: PubHealth{Requests: 5, Successes: 3, Bytes: 150, LatencyMs: 60, NextCheck: 40}},
+			latest: before,
+			want:   Pub{URL: "a", LastChecked: 30, LastUpdated: 5, Failures: 0, Health: PubHealth{Requests: 5, Successes: 3, Bytes: 150, LatencyMs: 60, NextCheck: 40}},
+		},
+		{
+			name:   "an announcement fetched meanwhile",
+			after:  Pub{URL: "a", LastChecked: 30, LastUpdated: 5, Failures: 3, Health: PubHealth{Requests: 5, Successes: 2, Bytes: 100, LatencyMs: 50, NextCheck: 40}},
+			latest: Pub{URL: "a", LastChecked: 10, LastUpdated: 25, Failures: 2, Health: PubHealth{Requests: 5, Successes: 3, Bytes: 300, LatencyMs: 70, NextCheck: 20}},
+			want:   Pub{URL: "a", LastChecked: 30, LastUpdated: 25, Failures: 3, Health: PubHealth{Requests: 6, Successes: 3, Bytes: 300, LatencyMs: 70, NextCheck: 40}},
+		},
+		{
+			name:   "times don't go backwards",
+			after:  Pub{URL: "a", LastChecked: 30, LastUpdated: 5, Failures: 2, Health: before.Health},
+			latest: Pub{URL: "a", LastChecked: 50, LastUpdated: 45, Failures: 2, Health: before.Health},
+			want:   Pub{URL: "a", LastChecked: 50, LastUpdated: 45, Failures: 2, Health: before.Health},
+		},
+	}
+
+	for _, c := range cases {
+		got := c.latest
+		got.Merge(before, c.after)
+		if got != c.want {
+			t.Errorf("%s: got %+v, want %+v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestMergeInfo(t *testing.T) {
+	old := &Info{Protocol: 1, Checked: 10}
+	newer := &Info{Protocol: 2, Checked: 20}
+
+	p := Pub{Info: newer}
+	p.Merge(Pub{}, Pub{Info: old})
+	if p.Info != newer {
+		t.Errorf("an older info replaced a newer one")
+	}
+	p = Pub{Info: old}
+	p.Merge(Pub{Info: old}, Pub{Info: newer})
+	if p.Info != newer {
+		t.Errorf("a newer info wasn't taken")
+	}
+}
@@ -1,6 +1,7 @@
 package feed
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -13,6 +14,18 @@ type Getter interface {
 	Get(string) (*http.Response, error)
 }
 
+// ContextGetter is a Getter that can abandon a request once its context is done
+type ContextGetter interface {
+	Getter
+	GetContext(context.Context, string) (*http.Response, error)
+}
+
+// HeaderGetter is a ContextGetter that can send request headers, eg for a conditional get
+type HeaderGetter interface {
+	ContextGetter
+	GetHeaders(context.Context, string, http.Header) (*http.Response, error)
+}
+
 var once sync.Once
 var instance Getter
 
@@ -27,30 +40,85 @@ func Initialize(getter Getter) {
 // Get executes an HTTP get
 // Calling Get before Initialize is an error
 func Get(requestedURL string) (*http.Response, error) {
+	return GetContext(context.Background(), requestedURL)
+}
+
+// GetContext executes an HTTP get that gives up once ctx is done
+// A Getter that isn't a ContextGetter is left to finish in the background,
+// and its response is thrown away
+func GetContext(ctx context.Context, requestedURL string) (*http.Response, error) {
 	if instance == nil {
 		panic("Calling Get before initialization")
 	}
+	requestedURL, err := rewriteURL(requestedURL)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("Fetching: %s\n", requestedURL)
 
-	// Handle special sandstorm URL format
-	splot := strings.Split(requestedURL, "#")
-	if len(splot) == 2 {
-		u, err := url.Parse(splot[0])
-		if err != nil {
-			return nil, err
-		}
-		u.User = url.UserPassword("anonymous-user", splot[1])
-		// HACK: if we know the node is a sandstorm node,
-		// we have to drop "sync" from the path as sandstorm will add it automatically
-		// as a defense mechanism
-		p := u.EscapedPath()
-		newPath := strings.Replace(p, "/sync", "", -1)
-		u.Path = newPath
-		if u.Scheme == "" {
-			u.Scheme = "https" // be optimistic
-		}
-
-		requestedURL = u.String()
+	if g, ok := instance.(ContextGetter); ok {
+		return g.GetContext(ctx, requestedURL)
+	}
+	if ctx.Done() == nil {
+		return instance.Get(requestedURL)
+	}
+
+	type result struct {
+		r   *http.Response
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		r, err := instance.Get(requestedURL)
+		done <- result{r, err}
+	}()
+	select {
+	case res := <-done:
+		return res.r, res.err
+	case <-ctx.Done():
+		go func() {
+			if res := <-done; res.err == nil {
+				res.r.Body.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// GetIfNoneMatch executes an HTTP get that the server can answer with a 304
+// if etag still matches; a Getter that can't send headers always gets the full response
+func GetIfNoneMatch(ctx context.Context, requestedURL string, etag string) (*http.Response, error) {
+	g, ok := instance.(HeaderGetter)
+	if etag == "" || !ok {
+		return GetContext(ctx, requestedURL)
+	}
+	requestedURL, err := rewriteURL(requestedURL)
+	if err != nil {
+		return nil, err
 	}
 	fmt.Printf("Fetching: %s\n", requestedURL)
-	return instance.Get(requestedURL)
+	return g.GetHeaders(ctx, requestedURL, http.Header{"If-None-Match": {etag}})
+}
+
+// rewriteURL handles the special sandstorm URL format
+func rewriteURL(requestedURL string) (string, error) {
+	splot := strings.Split(requestedURL, "#")
+	if len(splot) != 2 {
+		return requestedURL, nil
+	}
+	u, err := url.Parse(splot[0])
+	if err != nil {
+		return "", err
+	}
+	u.User = url.UserPassword("anonymous-user", splot[1])
+	// HACK: if we know the node is a sandstorm node,
+	// we have to drop "sync" from the path as sandstorm will add it automatically
+	// as a defense mechanism
+	p := u.EscapedPath()
+	newPath := strings.Replace(p, "/sync", "", -1)
+	u.Path = newPath
+	if u.Scheme == "" {
+		u.Scheme = "https" // be optimistic
+	}
+	return u.String(), nil
 }
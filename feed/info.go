@@ -0,0 +1,129 @@
+package feed
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// ProtocolVersion is the version of the sync protocol this node speaks
+// Nodes from before it was versioned are version 1
+const ProtocolVersion = 2
+
+// Capabilities a node can have beyond the version 1 protocol
+const (
+	CapDigest   = "digest"   // DigestPath, and heads by bucket
+	CapETag     = "etag"     // conditional gets on sync paths
+	CapSigned   = "signed"   // signed announcements
+	CapPushOps  = "push-ops" // ops pushed in announcements
+	CapBlocking = "block"    // blocked feeds aren't served
+)
+
+// capabilities is what this node supports
+var capabilities = []string{CapDigest, CapETag, CapSigned, CapPushOps, CapBlocking}
+
+// KeyAlgorithms are the algorithms this node signs and verifies feeds with
+var KeyAlgorithms = []string{"RS256"}
+
+// SoftwareVersion is the version of mark, which can be set at build time
+// with -ldflags "-X github.com/awans/mark/feed.SoftwareVersion=..."
+var SoftwareVersion = "0"
+
+// infoMaxAge is how long a pub's info is trusted before it's asked again
+const infoMaxAge = 24 * time.Hour
+
+// Info describes what a node speaks
+type Info struct {
+	Protocol      int      `json:"protocol"`
+	Software      string   `json:"software"`
+	Ops           []string `json:"ops"`
+	KeyAlgorithms []string `json:"key_algorithms"`
+	Capabilities  []string `json:"capabilities"`
+	Checked       int64    `json:"checked,omitempty"` // when we last asked
+}
+
+// LocalInfo describes this node, which understands ops
+func LocalInfo(ops []string) Info {
+	return Info{
+		Protocol:      ProtocolVersion,
+		Software:      SoftwareVersion,
+		Ops:           ops,
+		KeyAlgorithms: KeyAlgorithms,
+		Capabilities:  capabilities,
+	}
+}
+
+// legacyInfo is what we assume of a node that has no info path
+func legacyInfo() *Info {
+	return &Info{Protocol: 1, Checked: time.Now().Unix()}
+}
+
+// Supports says whether a node has a capability
+// A nil Info is a node we haven't asked yet, so it's assumed to have none
+func (i *Info) Supports(capability string) bool {
+	if i == nil {
+		return false
+	}
+	for _, c := range i.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// Understands says whether a node can decode an op type
+func (i *Info) Understands(op string) bool {
+	if i == nil {
+		return false
+	}
+	for _, o := range i.Ops {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// Ops returns the op types a coder understands
+func (c *Coder) Ops() []string {
+	var ops []string
+	for name := range c.registry {
+		ops = append(ops, name)
+	}
+	sort.Strings(ops)
+	return ops
+}
+
+// GetInfo issues a request to a pub for what it speaks
+func (p *Pub) GetInfo() (*Info, error) {
+	info, _, err := p.getInfo(context.Background())
+	return info, err
+}
+
+func (p *Pub) getInfo(ctx context.Context) (*Info, int64, error) {
+	var info Info
+	n, err := p.getJSON(ctx, nil, &info, InfoPath)
+	if err != nil {
+		return nil, n, err
+	}
+	info.Checked = time.Now().Unix()
+	return &info, n, nil
+}
+
+// refreshInfo asks a pub what it speaks, if we haven't recently
+// A pub that has no info path, or can't say, is taken to speak version 1,
+// but one that doesn't answer at all is asked again next time
+func (p *Pub) refreshInfo(ctx context.Context) int64 {
+	if p.Info != nil && time.Since(time.Unix(p.Info.Checked, 0)) < infoMaxAge {
+		return 0
+	}
+	info, n, err := p.getInfo(ctx)
+	if err == nil {
+		p.Info = info
+	} else if ctx.Err() == nil && (n > 0 || isStatus(err, http.StatusNotFound)) {
+		p.Info = legacyInfo()
+	}
+	return n
+}
@@ -0,0 +1,80 @@
+package feed
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRefreshInfo(t *testing.T) {
+	recent := &Info{Protocol: 3, Checked: time.Now().Unix()}
+	stale := &Info{Protocol: 3, Checked: time.Now().Add(-2 * infoMaxAge).Unix()}
+
+	cases := []struct {
+		name   string
+		code   int
+		body   string
+		info   *Info // what the pub had before
+		cancel bool
+		asked  bool
+		want   int // the protocol the pub is taken to speak, or 0 for none
+	}{
+		{name: "current", code: http.StatusOK, body: `{"protocol": 2, "capabilities": ["etag"]}`, asked: true, want: 2},
+		{name: "no info path", code: http.StatusNotFound, asked: true, want: 1},
+		{name: "can't say", code: http.StatusOK, body: "<html>", asked: true, want: 1},
+		{name: "broken", code: http.StatusInternalServerError, asked: true, want: 0},
+		{name: "asked recently", code: http.StatusOK, body: `{"protocol": 2}`, info: recent, want: 3},
+		{name: "asked long ago", code: http.StatusOK, body: `{"protocol": 2}`, info: stale, asked: true, want: 2},
+		{name: "stale and broken", code: http.StatusInternalServerError, info: stale, asked: true, want: 3},
+		{name: "given up on", code: http.StatusNotFound, cancel: true, want: 0},
+	}
+
+	for _, c := range cases {
+		asked := false
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/"+ProtocolRoot+"/"+InfoPath {
+				t.Errorf("%s: asked for %s", c.name, r.URL.Path)
+			}
+			asked = true
+			w.WriteHeader(c.code)
+			w.Write([]byte(c.body))
+		}))
+		ctx, cancel := context.WithCancel(context.Background())
+		if c.cancel {
+			cancel()
+		}
+
+		p := Pub{URL: s.URL, Info: c.info}
+		p.refreshInfo(ctx)
+		cancel()
+		s.Close()
+
+		if asked != c.asked {
+			t.Errorf("%s: asked is %v, want %v", c.name, asked, c.asked)
+		}
+		got := 0
+		if p.Info != nil {
+			got = p.Info.Protocol
+		}
+		if got != c.want {
+			t.Errorf("%s: got protocol %d, want %d", c.name, got, c.want)
+		}
+		// whatever a pub says, or doesn't, is good until infoMaxAge from now
+		if p.Info != nil && p.Info != c.info && time.Since(time.Unix(p.Info.Checked, 0)) > time.Minute {
+			t.Errorf("%s: checked at %d", c.name, p.Info.Checked)
+		}
+	}
+
+}
+
+func TestUnreachablePubIsAskedAgain(t *testing.T) {
+	s := httptest.NewServer(http.NotFoundHandler())
+	s.Close()
+	p := Pub{URL: s.URL}
+	p.refreshInfo(context.Background())
+	if p.Info != nil {
+		t.Errorf("got %+v", p.Info)
+	}
+}
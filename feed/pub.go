@@ -1,23 +1,47 @@
 package feed
 
 import (
+	"context"
+	"crypto/rsa"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"net/url"
 	"path"
+	"strings"
 	"time"
-)
 
-const failOut = 10
+	"github.com/square/go-jose"
+)
 
 // Pub represents a URL-addressable node
 type Pub struct {
-	URL         string `json:"url"`
-	LastChecked int64  `json:"last_checked"`
-	LastUpdated int64  `json:"last_updated"`
-	Failures    int    `json:"failures"`
+	URL         string    `json:"url"`
+	LastChecked int64     `json:"last_checked"`
+	LastUpdated int64     `json:"last_updated"`
+	Failures    int       `json:"failures"`       // in a row
+	Info        *Info     `json:"info,omitempty"` // what it speaks, once we've asked
+	Health      PubHealth `json:"health"`
+}
+
+// ListedPub is how a pub appears when another pub lists the pubs it knows
+// It's only the url: what that pub has seen of it changes every sync,
+// and we'd rather find out for ourselves
+type ListedPub struct {
+	URL string `json:"url"`
+}
+
+// ListPubs makes the public listing of pubs
+func ListPubs(pubs []Pub) []ListedPub {
+	listed := make([]ListedPub, len(pubs))
+	for i, p := range pubs {
+		listed[i] = ListedPub{URL: p.URL}
+	}
+	return listed
 }
 
 // Head is the length of a feed
@@ -27,21 +51,149 @@ type Head struct {
 }
 
 // Announcement is a pub and one or more heads for that pub
+// Ops optionally carries the last ops of a head's feed, keyed by feed id,
+// so a receiver that's only a little behind doesn't have to fetch anything
+// It's signed by the key of the feed From, which has to have declared the pub
 type Announcement struct {
-	Pub   Pub    `json:"pub"`
-	Heads []Head `json:"heads"`
+	From  string                `json:"from"`
+	Time  int64                 `json:"time"`
+	Pub   Pub                   `json:"pub"`
+	Heads []Head                `json:"heads"`
+	Ops   map[string]SignedFeed `json:"ops,omitempty"`
 }
 
-// ShouldUpdate says whether this pub should be checked
-// uses exponential backoff
-func (p *Pub) ShouldUpdate() bool {
-	if p.Failures > failOut {
-		return false
+// maxAnnouncementAge is how far an announcement's Time can be from ours,
+// so old ones can't be replayed indefinitely
+const maxAnnouncementAge = 10 * time.Minute
+
+// maxPushedOps caps the size of the ops pushed in an announcement,
+// since it has to fit in a url
+const maxPushedOps = 4096
+
+// Push adds the last n ops of sf to the announcement, if they're small enough
+func (a *Announcement) Push(sf SignedFeed, n int) error {
+	if n > len(sf) {
+		n = len(sf)
+	}
+	ops := sf[len(sf)-n:]
+	size := 0
+	for _, op := range ops {
+		size += len(op)
+	}
+	if size > maxPushedOps {
+		return nil
+	}
+	fp, err := sf.Fingerprint()
+	if err != nil {
+		return err
+	}
+	if a.Ops == nil {
+		a.Ops = make(map[string]SignedFeed)
+	}
+	a.Ops[fp] = ops
+	return nil
+}
+
+// Pushed returns the feed a head describes, built from the ops pushed for it
+// on top of the start of local, or false if they don't make a verified feed
+func (a *Announcement) Pushed(h Head, local SignedFeed) (SignedFeed, bool) {
+	ops := a.Ops[h.ID]
+	start := h.Len - len(ops)
+	if len(ops) == 0 || start < 0 || start > len(local) {
+		return nil, false
+	}
+	sf := append(append(SignedFeed{}, local[:start]...), ops...)
+	fp, err := sf.Fingerprint()
+	if err != nil || fp != h.ID {
+		return nil, false
+	}
+	if sf.VerifyFrom(start) != nil {
+		return nil, false
+	}
+	return sf, true
+}
+
+// Sign stamps an announcement and signs it with the key of the feed it's from,
+// in compact JWS serialization
+func (a *Announcement) Sign(key *rsa.PrivateKey) (string, error) {
+	fp, err := Fingerprint(&key.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	a.From = fp
+	a.Time = time.Now().Unix()
+	payload, err := json.Marshal(a)
+	if err != nil {
+		return "", err
+	}
+	signer, err := jose.NewSigner(jose.RS256, key)
+	if err != nil {
+		return "", err
+	}
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		return "", err
+	}
+	return jws.CompactSerialize()
+}
+
+// OpenAnnouncement reads a signed announcement without verifying it,
+// so the receiver can find the key of the feed it claims to be from
+func OpenAnnouncement(s string) (*Announcement, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("Malformed announcement")
+	}
+	bytes, err := base64URLDecode(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	var a Announcement
+	err = json.Unmarshal(bytes, &a)
+	return &a, err
+}
+
+// VerifyAnnouncement checks that a signed announcement is recent and was signed
+// by the key of sf, the feed it's from
+func VerifyAnnouncement(s string, sf SignedFeed) (*Announcement, error) {
+	if len(sf) == 0 {
+		return nil, errors.New("Unknown feed")
+	}
+	key, err := sf.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	jws, err := jose.ParseSigned(s)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := jws.Verify(key)
+	if err != nil {
+		return nil, err
+	}
+	var a Announcement
+	err = json.Unmarshal(payload, &a)
+	if err != nil {
+		return nil, err
 	}
-	now := time.Now().Unix()
-	uncertainDuration := now - p.LastChecked
-	staleDuration := p.LastChecked - p.LastUpdated
-	return uncertainDuration >= staleDuration
+	fp, err := sf.Fingerprint()
+	if err != nil {
+		return nil, err
+	}
+	if a.From != fp {
+		return nil, errors.New("Announcement isn't from " + fp)
+	}
+	age := time.Since(time.Unix(a.Time, 0))
+	if age > maxAnnouncementAge || age < -maxAnnouncementAge {
+		return nil, errors.New("Stale announcement")
+	}
+	return &a, nil
+}
+
+// ShouldUpdate says whether this pub is due to be checked
+// A pub we've never polled is due straight away
+func (p *Pub) ShouldUpdate() bool {
+	return time.Now().Unix() >= p.Health.NextCheck
 }
 
 // URLHash is a short way to identify pubs
@@ -54,60 +206,199 @@ func (p *Pub) URLHash() []byte {
 
 // GetHeads issues a request to a pub to fetch the head of each feed it has
 func (p *Pub) GetHeads() ([]Head, error) {
-	u, err := url.Parse(p.URL)
-	u.Path = path.Join(u.Path, ProtocolRoot, HeadsPath)
-	s := u.String()
+	heads, _, err := p.getHeads(context.Background(), nil)
+	return heads, err
+}
 
-	r, err := Get(s)
+// GetHeadsIfNoneMatch is GetHeads, but returns ErrNotModified
+// if the heads still match etag, and otherwise the heads' new tag
+func (p *Pub) GetHeadsIfNoneMatch(etag string) ([]Head, string, error) {
+	u, err := p.protocolURL(HeadsPath)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+	body, tag, err := p.get(context.Background(), u, etag)
+	if err != nil {
+		return nil, tag, err
 	}
-	defer r.Body.Close()
 	var heads []Head
-	err = json.NewDecoder(r.Body).Decode(&heads)
+	err = json.Unmarshal(body, &heads)
+	return heads, tag, err
+}
+
+func (p *Pub) getHeads(ctx context.Context, c *etagCache) ([]Head, int64, error) {
+	var heads []Head
+	n, err := p.getJSON(ctx, c, &heads, HeadsPath)
+	return heads, n, err
+}
+
+// GetDigest issues a request to a pub for a digest of its heads
+func (p *Pub) GetDigest() (*HeadsDigest, error) {
+	d, _, err := p.getDigest(context.Background(), nil)
+	return d, err
+}
+
+func (p *Pub) getDigest(ctx context.Context, c *etagCache) (*HeadsDigest, int64, error) {
+	var d HeadsDigest
+	n, err := p.getJSON(ctx, c, &d, DigestPath)
+	if err == nil && len(d.Buckets) != DigestBuckets {
+		err = errors.New("Bad digest from " + p.URL)
+	}
+	return &d, n, err
+}
+
+// GetHeadsIn issues a request to a pub for the heads in some digest buckets
+func (p *Pub) GetHeadsIn(buckets []int) ([]Head, error) {
+	heads, _, err := p.getHeadsIn(context.Background(), nil, buckets)
 	return heads, err
 }
 
+func (p *Pub) getHeadsIn(ctx context.Context, c *etagCache, buckets []int) ([]Head, int64, error) {
+	u, err := p.protocolURL(HeadsPath)
+	if err != nil {
+		return nil, 0, err
+	}
+	q := u.Query()
+	q.Set("buckets", FormatBuckets(buckets))
+	u.RawQuery = q.Encode()
+	var heads []Head
+	n, err := p.getCached(ctx, c, &heads, u)
+	return heads, n, err
+}
+
 // GetPubs issues a request to load the pubs another pub knows about
 func (p *Pub) GetPubs() ([]Pub, error) {
-	u, err := url.Parse(p.URL)
-	u.Path = path.Join(u.Path, ProtocolRoot, PubsPath)
-	s := u.String()
-	r, err := Get(s)
+	pubs, _, err := p.getPubs(context.Background(), nil)
+	return pubs, err
+}
+
+func (p *Pub) getPubs(ctx context.Context, c *etagCache) ([]Pub, int64, error) {
+	var listed []ListedPub
+	n, err := p.getJSON(ctx, c, &listed, PubsPath)
 	if err != nil {
-		return nil, err
+		return nil, n, err
 	}
-	defer r.Body.Close()
-	var pubs []Pub
-	err = json.NewDecoder(r.Body).Decode(&pubs)
-	return pubs, err
+	pubs := make([]Pub, len(listed))
+	for i, l := range listed {
+		pubs[i] = Pub{URL: l.URL}
+	}
+	return pubs, n, nil
 }
 
 // GetFeed issues a request to load a specific feed from the pub
 func (p *Pub) GetFeed(feedID string) (*SignedFeed, error) {
+	sf, _, _, err := p.getFeed(context.Background(), feedID, "")
+	return sf, err
+}
+
+// GetFeedIfNoneMatch is GetFeed, but returns ErrNotModified if the pub's copy
+// still matches etag, eg the FeedETag of ours, and otherwise the feed's new tag
+func (p *Pub) GetFeedIfNoneMatch(feedID string, etag string) (*SignedFeed, string, error) {
+	sf, tag, _, err := p.getFeed(context.Background(), feedID, etag)
+	return sf, tag, err
+}
+
+func (p *Pub) getFeed(ctx context.Context, feedID string, etag string) (*SignedFeed, string, int64, error) {
+	u, err := p.protocolURL(FeedPath, feedID)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	body, tag, err := p.get(ctx, u, etag)
+	n := int64(len(body))
+	if err != nil {
+		return nil, tag, n, err
+	}
+	var sf SignedFeed
+	err = json.Unmarshal(body, &sf)
+	return &sf, tag, n, err
+}
+
+// getJSON decodes the response to a protocol path into v,
+// and returns how many bytes were read
+func (p *Pub) getJSON(ctx context.Context, c *etagCache, v interface{}, elem ...string) (int64, error) {
+	u, err := p.protocolURL(elem...)
+	if err != nil {
+		return 0, err
+	}
+	return p.getCached(ctx, c, v, u)
+}
+
+// protocolURL is the url of a protocol path on this pub
+func (p *Pub) protocolURL(elem ...string) (*url.URL, error) {
 	u, err := url.Parse(p.URL)
-	u.Path = path.Join(u.Path, ProtocolRoot, FeedPath, feedID)
-	s := u.String()
-	r, err := Get(s)
 	if err != nil {
 		return nil, err
 	}
+	u.Path = path.Join(append([]string{u.Path, ProtocolRoot}, elem...)...)
+	return u, nil
+}
+
+// getCached decodes the response to u into v, and returns how many bytes were read
+// The request is conditional on what c kept from last time, and a 304 decodes that
+func (p *Pub) getCached(ctx context.Context, c *etagCache, v interface{}, u *url.URL) (int64, error) {
+	e, _ := c.get(u.String())
+	body, tag, err := p.get(ctx, u, e.etag)
+	if err == ErrNotModified {
+		return 0, json.Unmarshal(e.body, v)
+	}
+	if err != nil {
+		return int64(len(body)), err
+	}
+	c.put(u.String(), etagEntry{etag: tag, body: body})
+	return int64(len(body)), json.Unmarshal(body, v)
+}
+
+// StatusError is a pub's answer that was neither a success nor a 304
+type StatusError struct {
+	URL  string
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s answered %d %s", e.URL, e.Code, http.StatusText(e.Code))
+}
+
+// isStatus is whether err is a pub answering with code
+func isStatus(err error, code int) bool {
+	se, ok := err.(*StatusError)
+	return ok && se.Code == code
+}
+
+// get reads the response to u, sending etag as If-None-Match if it's set
+// It returns the response's ETag, or ErrNotModified if the server answered 304
+// Any other answer but a success is a StatusError, and its body isn't read
+func (p *Pub) get(ctx context.Context, u *url.URL, etag string) ([]byte, string, error) {
+	r, err := GetIfNoneMatch(ctx, u.String(), etag)
+	if err != nil {
+		return nil, "", err
+	}
 	defer r.Body.Close()
-	var sf SignedFeed
-	err = json.NewDecoder(r.Body).Decode(&sf)
-	return &sf, err
+	if r.StatusCode == http.StatusNotModified {
+		return nil, etag, ErrNotModified
+	}
+	if r.StatusCode < 200 || r.StatusCode > 299 {
+		return nil, "", &StatusError{URL: u.String(), Code: r.StatusCode}
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	return body, r.Header.Get("ETag"), err
 }
 
 // Announce posts an announcement to a feed
-func (p *Pub) Announce(a *Announcement) error {
+// signed is the announcement as made by Sign
+func (p *Pub) Announce(signed string) error {
 	u, err := url.Parse(p.URL)
+	if err != nil {
+		return err
+	}
 	u.Path = path.Join(u.Path, ProtocolRoot, AnnouncePath)
-	urlToAnnounce := a.Pub.URL
 	q := u.Query()
-	q.Set("url", urlToAnnounce)
+	q.Set("announcement", signed)
 	u.RawQuery = q.Encode()
 	s := u.String()
-	fmt.Printf("Announcing to: %s\n", s)
-	_, err = Get(s)
-	return err
+	fmt.Printf("Announcing to: %s\n", p.URL)
+	r, err := Get(s)
+	if err != nil {
+		return err
+	}
+	return r.Body.Close()
 }
@@ -0,0 +1,216 @@
+package feed
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testGetter fetches with net/http, the way the mark command's getter does
+type testGetter struct{}
+
+func (g testGetter) Get(u string) (*http.Response, error) {
+	return http.Get(u)
+}
+
+func (g testGetter) GetContext(ctx context.Context, u string) (*http.Response, error) {
+	return g.GetHeaders(ctx, u, nil)
+}
+
+func (g testGetter) GetHeaders(ctx context.Context, u string, h http.Header) (*http.Response, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = h
+	return http.DefaultClient.Do(req.WithContext(ctx))
+}
+
+func init() {
+	Initialize(testGetter{})
+}
+
+func TestGetStatus(t *testing.T) {
+	cases := []struct {
+		name string
+		code int
+		etag string
+		err  error
+	}{
+		{"ok", http.StatusOK, "", nil},
+		{"not modified", http.StatusNotModified, `"a"`, ErrNotModified},
+		{"not found", http.StatusNotFound, "", &StatusError{Code: http.StatusNotFound}},
+		{"server error", http.StatusInternalServerError, "", &StatusError{Code: http.StatusInternalServerError}},
+		{"rate limited", http.StatusTooManyRequests, "", &StatusError{Code: http.StatusTooManyRequests}},
+	}
+
+	for _, c := range cases {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("ETag", `"b"`)
+			w.WriteHeader(c.code)
+			w.Write([]byte(`["x"]`))
+		}))
+		u, _ := url.Parse(s.URL)
+		if se, ok := c.err.(*StatusError); ok {
+			se.URL = s.URL
+		}
+
+		p := Pub{URL: s.URL}
+		body, _, err := p.get(context.Background(), u, c.etag)
+		if !reflect.DeepEqual(err, c.err) {
+			t.Errorf("%s: got %v, want %v", c.name, err, c.err)
+		}
+		if c.err != nil && body != nil {
+			t.Errorf("%s: read %q", c.name, body)
+		}
+
+		// only a success is kept to be revalidated
+		cache := newETagCache()
+		var v []string
+		p.getCached(context.Background(), cache, &v, u)
+		if _, ok := cache.get(u.String()); ok != (c.code == http.StatusOK) {
+			t.Errorf("%s: cached is %v", c.name, ok)
+		}
+		s.Close()
+	}
+}
+
+func TestListedPubsAreOnlyURLs(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"url": "http://a", "last_checked": 5, "last_updated": 4, "failures": 3}]`))
+	}))
+	defer s.Close()
+
+	p := Pub{URL: s.URL}
+	pubs, err := p.GetPubs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Pub{{URL: "http://a"}}; !reflect.DeepEqual(pubs, want) {
+		t.Errorf("got %+v, want %+v", pubs, want)
+	}
+}
+
+func TestListPubsETagSurvivesSync(t *testing.T) {
+	pubs := []Pub{{URL: "http://a"}, {URL: "http://b", Failures: 2}}
+	etag := func() string {
+		b, err := json.Marshal(ListPubs(pubs))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ETag(b)
+	}
+	before := etag()
+
+	// what a sync cycle records of each pub
+	now := time.Now()
+	pubs[0].recordSuccess(100*time.Millisecond, 10)
+	pubs[0].LastChecked = now.Unix()
+	pubs[0].LastUpdated = now.Unix()
+	pubs[0].Info = &Info{Protocol: ProtocolVersion, Checked: now.Unix()}
+	pubs[0].schedule(now)
+	pubs[1].recordFailure(5)
+	pubs[1].Failures++
+	pubs[1].schedule(now)
+
+	if after := etag(); after != before {
+		t.Errorf("etag went from %s to %s", before, after)
+	}
+}
+
+// testFeed is a signed feed of n ops
+func testFeed(t *testing.T, n int) SignedFeed {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := New(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for len(f.Ops) < n {
+		err = f.Append(Op{Op: "note", Body: len(f.Ops)}, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	sf, err := NewCoder().Encode(f, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sf
+}
+
+func TestPushed(t *testing.T) {
+	sf := testFeed(t, 5)
+	fp, err := sf.Fingerprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := testFeed(t, 5)
+	head := Head{ID: fp, Len: len(sf)}
+
+	cases := []struct {
+		name  string
+		ops   SignedFeed
+		head  Head
+		local SignedFeed
+		ok    bool
+	}{
+		{"one behind", sf[4:], head, sf[:4], true},
+		{"further behind than pushed", sf[4:], head, sf[:3], false},
+		{"pushed covers the gap", sf[2:], head, sf[:3], true},
+		{"the whole feed to someone new", sf, head, nil, true},
+		{"nothing pushed", nil, head, sf[:4], false},
+		{"more pushed than the head", sf[3:], Head{ID: fp, Len: 1}, sf[:1], false},
+		{"another feed's ops", other[4:], head, sf[:4], false},
+		{"ops out of order", SignedFeed{sf[4], sf[3]}, head, sf[:3], false},
+	}
+	for _, c := range cases {
+		a := Announcement{Ops: map[string]SignedFeed{fp: c.ops}}
+		got, ok := a.Pushed(c.head, c.local)
+		if ok != c.ok {
+			t.Errorf("%s: got %v, want %v", c.name, ok, c.ok)
+		}
+		if ok && !reflect.DeepEqual(got, sf) {
+			t.Errorf("%s: got %d ops that aren't the feed", c.name, len(got))
+		}
+	}
+}
+
+func TestPush(t *testing.T) {
+	sf := testFeed(t, 3)
+	fp, err := sf.Fingerprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var a Announcement
+	if err := a.Push(sf, 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := a.Ops[fp]; !reflect.DeepEqual(got, sf[2:]) {
+		t.Errorf("pushed %d ops, want the last one", len(got))
+	}
+	if err := a.Push(sf, 10); err != nil {
+		t.Fatal(err)
+	}
+	if got := a.Ops[fp]; !reflect.DeepEqual(got, sf) {
+		t.Errorf("pushed %d ops, want all %d", len(got), len(sf))
+	}
+
+	// ops too big for a url aren't pushed; the receiver fetches the feed instead
+	big := SignedFeed{sf[0], strings.Repeat("x", maxPushedOps+1)}
+	var b Announcement
+	if err := b.Push(big, 1); err != nil || b.Ops != nil {
+		t.Errorf("pushed %v, %v", b.Ops, err)
+	}
+}
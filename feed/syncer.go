@@ -0,0 +1,358 @@
+package feed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults for a Syncer
+const (
+	DefaultSyncWorkers = 4
+	DefaultPubTimeout  = 10 * time.Second
+)
+
+// ErrSyncRunning is returned instead of starting a run while another is in progress
+var ErrSyncRunning = errors.New("Sync already running")
+
+// SyncStats summarizes one sync run
+type SyncStats struct {
+	Pubs     int // pubs contacted
+	Feeds    int // feeds fetched that are longer than ours
+	Bytes    int64
+	Failures int
+	Duration time.Duration
+}
+
+func (s SyncStats) String() string {
+	return fmt.Sprintf("%d pubs, %d feeds updated, %d bytes, %d failures in %v",
+		s.Pubs, s.Feeds, s.Bytes, s.Failures, s.Duration)
+}
+
+// Syncer talks to several pubs at once, and runs one sync at a time
+type Syncer struct {
+	workers int
+	timeout time.Duration
+	running int32
+	cache   *etagCache // so pubs that haven't changed can answer with a 304
+}
+
+// NewSyncer makes a Syncer with a pool of workers
+// Each request to a pub gives up after timeout; 0 means no timeout
+func NewSyncer(workers int, timeout time.Duration) *Syncer {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Syncer{workers: workers, timeout: timeout, cache: newETagCache()}
+}
+
+type pubLen struct {
+	Pub *Pub
+	Len int
+}
+
+// poll is what a pub told us about the pubs and feeds it has
+type poll struct {
+	pubs    []Pub
+	heads   []Head
+	bytes   int64
+	latency time.Duration
+	err     error
+}
+
+// fetch is a feed to load from a pub
+type fetch struct {
+	fp      string
+	pub     *Pub
+	sf      *SignedFeed
+	bytes   int64
+	latency time.Duration
+	err     error
+}
+
+// Run gets any new updates from the list of pubs, like Sync
+// Only feeds want says yes to are fetched; a nil want fetches everything
+// It fails with ErrSyncRunning rather than overlap a run that hasn't finished
+func (s *Syncer) Run(ctx context.Context, pubs []Pub, feeds []SignedFeed, want func(string) bool) ([]Pub, []SignedFeed, SyncStats, error) {
+	var stats SyncStats
+	if !atomic.CompareAndSwapInt32(&s.running, 0, 1) {
+		return nil, nil, stats, ErrSyncRunning
+	}
+	defer atomic.StoreInt32(&s.running, 0)
+	start := time.Now()
+
+	feedsByID := make(map[string]SignedFeed)
+	var local []Head
+	for _, feed := range feeds {
+		fp, err := feed.Fingerprint()
+		if err != nil {
+			return nil, nil, stats, err
+		}
+		feedsByID[fp] = feed
+		local = append(local, Head{ID: fp, Len: len(feed)})
+	}
+	digest := Digest(local)
+
+	existingPubsByURL := make(map[string]Pub)
+	for _, p := range pubs {
+		existingPubsByURL[string(p.URLHash())] = p
+	}
+
+	// ask every pub that's due what it has, healthiest first,
+	// so they're asked soonest and win ties for where to fetch a feed from
+	var due []*Pub
+	for i := range pubs {
+		if pubs[i].ShouldUpdate() {
+			due = append(due, &pubs[i])
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool { return due[i].Score() > due[j].Score() })
+	polls := make([]poll, len(due))
+	s.each(ctx, len(due), func(ctx context.Context, i int) {
+		pub := due[i]
+		fmt.Printf("Updating %s - times: %v %v %v\n", pub.URL, time.Now().Unix(),
+			pub.LastUpdated, pub.LastChecked)
+		pub.LastChecked = time.Now().Unix()
+		t := time.Now()
+		polls[i] = s.poll(ctx, pub, digest)
+		polls[i].latency = time.Since(t)
+	})
+
+	// then work out where the latest of each feed is
+	feedPubs := make(map[string]pubLen)
+	pubsByURL := make(map[string]Pub)
+	for i, pub := range due {
+		p := polls[i]
+		stats.Pubs++
+		stats.Bytes += p.bytes
+		if p.err != nil {
+			fmt.Println(p.err)
+			pub.Failures++
+			pub.recordFailure(p.bytes)
+			stats.Failures++
+			continue
+		}
+		pub.Failures = 0 // reset -- we had a sucessful response, so it's still alive
+		pub.recordSuccess(p.latency, p.bytes)
+
+		for _, pubToAdd := range p.pubs {
+			key := string(pubToAdd.URLHash())
+			if _, ok := existingPubsByURL[key]; !ok {
+				if _, ok := pubsByURL[key]; !ok {
+					pubsByURL[key] = pubToAdd
+				}
+			}
+		}
+
+		for _, head := range p.heads {
+			if want != nil && !want(head.ID) {
+				continue
+			}
+			best := -1
+			if pl, ok := feedPubs[head.ID]; ok {
+				best = pl.Len
+			} else if f, ok := feedsByID[head.ID]; ok {
+				best = len(f)
+			}
+			if head.Len > best {
+				if best == -1 {
+					fmt.Printf("Sync: new feed %s\n", head.ID)
+				} else {
+					fmt.Printf("Sync: updated feed %s - %d\n", head.ID, head.Len)
+				}
+				feedPubs[head.ID] = pubLen{Pub: pub, Len: head.Len}
+			}
+		}
+	}
+
+	// now we know where the latest feeds are, so let's get 'em
+	var fetches []fetch
+	for fp, pl := range feedPubs {
+		pl.Pub.LastUpdated = time.Now().Unix()
+		fetches = append(fetches, fetch{fp: fp, pub: pl.Pub})
+	}
+	s.each(ctx, len(fetches), func(ctx context.Context, i int) {
+		f := &fetches[i]
+		fetchFeed(ctx, f, feedsByID[f.fp])
+	})
+
+	var outFeeds []SignedFeed
+	for i := range fetches {
+		f := &fetches[i]
+		stats.Bytes += f.bytes
+		sf, err := settle(f, feedsByID[f.fp])
+		if err != nil {
+			fmt.Println(err)
+			stats.Failures++
+			continue
+		}
+		if sf == nil {
+			continue
+		}
+		fmt.Printf("Sync loaded feed: %s %s\n", f.fp, f.pub.URL)
+		outFeeds = append(outFeeds, sf)
+	}
+	stats.Feeds = len(outFeeds)
+
+	now := time.Now()
+	for _, pub := range due {
+		pub.schedule(now)
+	}
+
+	// save off any new friends
+	var outPubs []Pub
+	for _, p := range pubsByURL {
+		p.LastUpdated = time.Now().Unix()
+		outPubs = append(outPubs, p)
+	}
+
+	stats.Duration = time.Since(start)
+	return outPubs, outFeeds, stats, nil
+}
+
+// Fetch loads one feed from a pub the way Run does, within the pub timeout
+// It returns nil if the pub's copy is no newer than local
+func (s *Syncer) Fetch(ctx context.Context, pub *Pub, fp string, local SignedFeed) (SignedFeed, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	pub.refreshInfo(ctx)
+	f := fetch{fp: fp, pub: pub}
+	fetchFeed(ctx, &f, local)
+	return settle(&f, local)
+}
+
+// fetchFeed loads a feed, conditional on our copy if the pub can say it's unchanged
+func fetchFeed(ctx context.Context, f *fetch, local SignedFeed) {
+	var etag string
+	if len(local) > 0 && f.pub.Info.Supports(CapETag) {
+		etag = FeedETag(local)
+	}
+	t := time.Now()
+	f.sf, _, f.bytes, f.err = f.pub.getFeed(ctx, f.fp, etag)
+	f.latency = time.Since(t)
+}
+
+// settle records how a fetch went in its pub's health, and returns the feed
+// if it verifies and is newer than local, or nil if it isn't newer
+func settle(f *fetch, local SignedFeed) (SignedFeed, error) {
+	if f.err == ErrNotModified {
+		// the pub's copy turned out to be the same as ours
+		f.pub.recordSuccess(f.latency, f.bytes)
+		return nil, nil
+	}
+	if f.err != nil {
+		f.pub.recordFailure(f.bytes)
+		return nil, f.err
+	}
+	f.pub.recordSuccess(f.latency, f.bytes)
+	if err := verifyFetched(f.fp, *f.sf, local); err != nil {
+		f.pub.recordInvalid()
+		return nil, fmt.Errorf("Invalid feed %s from %s: %v", f.fp, f.pub.URL, err)
+	}
+	if len(*f.sf) <= len(local) {
+		return nil, nil
+	}
+	return *f.sf, nil
+}
+
+// verifyFetched checks a fetched feed is the one asked for and is signed by its key
+// Only the ops past our copy need checking, if it extends ours
+func verifyFetched(fp string, sf SignedFeed, local SignedFeed) error {
+	if len(sf) == 0 {
+		return errors.New("Empty feed")
+	}
+	fetchedFp, err := sf.Fingerprint()
+	if err != nil {
+		return err
+	}
+	if fetchedFp != fp {
+		return fmt.Errorf("Fingerprint mismatch: head.ID:%s FP:%s", fp, fetchedFp)
+	}
+	start := len(local)
+	if start > len(sf) {
+		start = 0
+	}
+	for i := 0; i < start; i++ {
+		if sf[i] != local[i] {
+			start = 0
+			break
+		}
+	}
+	if start == len(sf) {
+		return nil
+	}
+	return sf.VerifyFrom(start)
+}
+
+// poll asks a pub for its pubs and the heads that differ from ours,
+// in whatever form the pub's info says it understands
+// The pub's digest says which buckets of heads differ from local, so only
+// those are sent; a pub that can't give a digest sends all its heads
+func (s *Syncer) poll(ctx context.Context, pub *Pub, local HeadsDigest) poll {
+	var p poll
+	p.bytes += pub.refreshInfo(ctx)
+	var cache *etagCache
+	if pub.Info.Supports(CapETag) {
+		cache = s.cache
+	}
+
+	var n int64
+	p.pubs, n, p.err = pub.getPubs(ctx, cache)
+	p.bytes += n
+	if p.err != nil {
+		return p
+	}
+	if !pub.Info.Supports(CapDigest) {
+		p.heads, n, p.err = pub.getHeads(ctx, cache)
+		p.bytes += n
+		return p
+	}
+	d, n, err := pub.getDigest(ctx, cache)
+	p.bytes += n
+	if err != nil {
+		p.err = err
+		return p
+	}
+	differ := d.Differs(local)
+	if len(differ) == 0 {
+		return p
+	}
+	p.heads, n, p.err = pub.getHeadsIn(ctx, cache, differ)
+	p.bytes += n
+	return p
+}
+
+// withTimeout gives ctx the timeout for a request to a pub
+func (s *Syncer) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.timeout > 0 {
+		return context.WithTimeout(ctx, s.timeout)
+	}
+	return ctx, func() {}
+}
+
+// each calls fn for 0 through n-1 on the worker pool, each call with its own timeout
+// Calls that haven't started when ctx is done see it already cancelled
+func (s *Syncer) each(ctx context.Context, n int, fn func(context.Context, int)) {
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < s.workers && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				wctx, cancel := s.withTimeout(ctx)
+				fn(wctx, i)
+				cancel()
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+}
@@ -0,0 +1,152 @@
+package feed
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEachUsesTheWorkers(t *testing.T) {
+	cases := []struct {
+		workers, n int
+	}{
+		{1, 5},
+		{4, 20},
+		{4, 2},
+		{3, 0},
+	}
+
+	for _, c := range cases {
+		s := NewSyncer(c.workers, 0)
+		var mu sync.Mutex
+		running, most := 0, 0
+		calls := make([]int, c.n)
+		s.each(context.Background(), c.n, func(ctx context.Context, i int) {
+			mu.Lock()
+			running++
+			if running > most {
+				most = running
+			}
+			calls[i]++
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+		})
+
+		for i, n := range calls {
+			if n != 1 {
+				t.Errorf("%d workers, %d calls: call %d ran %d times", c.workers, c.n, i, n)
+			}
+		}
+		if most > c.workers {
+			t.Errorf("%d workers, %d calls: %d ran at once", c.workers, c.n, most)
+		}
+	}
+}
+
+func TestEachTimesOutEachCall(t *testing.T) {
+	s := NewSyncer(2, 10*time.Millisecond)
+	start := time.Now()
+	errs := make([]error, 4)
+	s.each(context.Background(), len(errs), func(ctx context.Context, i int) {
+		<-ctx.Done()
+		errs[i] = ctx.Err()
+	})
+	for i, err := range errs {
+		if err != context.DeadlineExceeded {
+			t.Errorf("call %d: got %v", i, err)
+		}
+	}
+	// two rounds of two calls, each given up on after the timeout
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("took %v", d)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s = NewSyncer(1, 0)
+	s.each(ctx, 1, func(ctx context.Context, i int) {
+		if ctx.Err() != context.Canceled {
+			t.Errorf("a call after the run was cancelled got %v", ctx.Err())
+		}
+	})
+}
+
+// testPub serves a version 1 pub with one feed, or fails every request
+func testPub(t *testing.T, sf SignedFeed, fail bool) *httptest.Server {
+	fp, err := sf.Fingerprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := "/" + ProtocolRoot
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var v interface{}
+		switch {
+		case fail:
+			http.Error(w, "down", http.StatusInternalServerError)
+			return
+		case r.URL.Path == path.Join(root, PubsPath):
+			v = []ListedPub{{URL: "http://new.example"}}
+		case r.URL.Path == path.Join(root, HeadsPath):
+			v = []Head{{ID: fp, Len: len(sf)}}
+		case r.URL.Path == path.Join(root, FeedPath, fp):
+			v = sf
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(v)
+	}))
+}
+
+func TestRunStats(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := New(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf, err := NewCoder().Encode(f, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	up := testPub(t, sf, false)
+	defer up.Close()
+	down := testPub(t, sf, true)
+	defer down.Close()
+
+	pubs := []Pub{{URL: up.URL}, {URL: down.URL}}
+	s := NewSyncer(2, time.Second)
+	newPubs, feeds, stats, err := s.Run(context.Background(), pubs, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.Pubs != 2 || stats.Feeds != 1 || stats.Failures != 1 {
+		t.Errorf("got %v, want 2 pubs, 1 feed and 1 failure", stats)
+	}
+	if stats.Bytes == 0 || stats.Duration == 0 {
+		t.Errorf("got %v, want some bytes and time", stats)
+	}
+	if len(feeds) != 1 || len(feeds[0]) != len(sf) {
+		t.Errorf("got feeds %v", feeds)
+	}
+	if len(newPubs) != 1 || newPubs[0].URL != "http://new.example" {
+		t.Errorf("got new pubs %v", newPubs)
+	}
+	if pubs[0].Failures != 0 || pubs[1].Failures != 1 {
+		t.Errorf("failures are %d and %d, want 0 and 1", pubs[0].Failures, pubs[1].Failures)
+	}
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/awans/mark/app"
+	"github.com/awans/mark/entities"
+	"github.com/gorilla/mux"
+)
+
+// Blocks is a resource for the feeds a user blocks or mutes
+type Blocks struct {
+	db *app.DB
+}
+
+// NewBlocks builds a blocks resource
+func NewBlocks(db *app.DB) *Blocks {
+	return &Blocks{db: db}
+}
+
+type feedRequest struct {
+	Feed string `json:"feed"`
+}
+
+// readFeedRequest reads the feed id from a request body, or writes a 422
+func readFeedRequest(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req feedRequest
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1048576))
+	if err != nil {
+		panic(err)
+	}
+	if err := r.Body.Close(); err != nil {
+		panic(err)
+	}
+	if err := json.Unmarshal(body, &req); err != nil || req.Feed == "" {
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(422) // unprocessable entity
+		if err := json.NewEncoder(w).Encode(err); err != nil {
+			panic(err)
+		}
+		return "", false
+	}
+	return req.Feed, true
+}
+
+func writeFeeds(w http.ResponseWriter, feeds []string) {
+	if feeds == nil {
+		feeds = make([]string, 0)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(feeds)
+}
+
+// GetBlocked returns the feeds the user blocks
+func (b *Blocks) GetBlocked(w http.ResponseWriter, r *http.Request) {
+	blocked, err := b.db.GetBlocked()
+	if err != nil {
+		panic(err)
+	}
+	writeFeeds(w, blocked)
+}
+
+// AddBlock blocks a feed
+func (b *Blocks) AddBlock(w http.ResponseWriter, r *http.Request) {
+	id, ok := readFeedRequest(w, r)
+	if !ok {
+		return
+	}
+	if err := b.db.Block(id); err != nil {
+		panic(err)
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// RemoveBlock unblocks a feed
+func (b *Blocks) RemoveBlock(w http.ResponseWriter, r *http.Request) {
+	err := b.db.Unblock(mux.Vars(r)["feed"])
+	if err == entities.ErrNotBlocked {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		panic(err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetMuted returns the feeds the user mutes
+func (b *Blocks) GetMuted(w http.ResponseWriter, r *http.Request) {
+	muted, err := b.db.GetMuted()
+	if err != nil {
+		panic(err)
+	}
+	writeFeeds(w, muted)
+}
+
+// AddMute mutes a feed
+func (b *Blocks) AddMute(w http.ResponseWriter, r *http.Request) {
+	id, ok := readFeedRequest(w, r)
+	if !ok {
+		return
+	}
+	if err := b.db.Mute(id); err != nil {
+		panic(err)
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// RemoveMute unmutes a feed
+func (b *Blocks) RemoveMute(w http.ResponseWriter, r *http.Request) {
+	if err := b.db.Unmute(mux.Vars(r)["feed"]); err != nil {
+		panic(err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -38,7 +38,11 @@ func (b *Bookmark) AddBookmark(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	err = b.db.AddBookmark(&bookmark)
+	err = b.db.AddBookmarkContext(r.Context(), &bookmark)
+	if r.Context().Err() != nil {
+		// the client went away
+		return
+	}
 	if err != nil {
 		panic(err)
 	}
@@ -53,7 +57,11 @@ func (b *Bookmark) AddBookmark(w http.ResponseWriter, r *http.Request) {
 // RemoveBookmark removes an existing bookmark
 func (b *Bookmark) RemoveBookmark(w http.ResponseWriter, r *http.Request) {
 	id := mux.Vars(r)["id"]
-	err := b.db.RemoveBookmark(id)
+	err := b.db.RemoveBookmarkContext(r.Context(), id)
+	if r.Context().Err() != nil {
+		// the client went away
+		return
+	}
 	if err != nil {
 		panic(err)
 	}
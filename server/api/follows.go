@@ -0,0 +1,85 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/awans/mark/app"
+	"github.com/gorilla/mux"
+)
+
+// Follows is a resource for who a user follows
+type Follows struct {
+	db *app.DB
+}
+
+// NewFollows builds a follows resource
+func NewFollows(db *app.DB) *Follows {
+	return &Follows{db: db}
+}
+
+type followRequest struct {
+	Target string `json:"target"`
+}
+
+// GetFollows returns the follows of the feed in feedId, or the current user's
+func (f *Follows) GetFollows(w http.ResponseWriter, r *http.Request) {
+	follows, err := f.db.GetFollows(r.URL.Query().Get("feedId"))
+	if err != nil {
+		panic(err)
+	}
+	if follows == nil {
+		follows = make([]app.Follow, 0)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(follows)
+}
+
+// AddFollow follows a feed
+func (f *Follows) AddFollow(w http.ResponseWriter, r *http.Request) {
+	var req followRequest
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1048576))
+	if err != nil {
+		panic(err)
+	}
+	if err := r.Body.Close(); err != nil {
+		panic(err)
+	}
+	if err := json.Unmarshal(body, &req); err != nil || req.Target == "" {
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(422) // unprocessable entity
+		if err := json.NewEncoder(w).Encode(err); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	follow, err := f.db.Follow(req.Target)
+	if err != nil {
+		panic(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(follow); err != nil {
+		panic(err)
+	}
+}
+
+// RemoveFollow unfollows a feed
+func (f *Follows) RemoveFollow(w http.ResponseWriter, r *http.Request) {
+	target := mux.Vars(r)["target"]
+	err := f.db.Unfollow(target)
+	if err == app.ErrNotFollowing {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		panic(err)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusNoContent)
+}
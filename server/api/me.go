@@ -47,7 +47,11 @@ func (m *Me) PutProfile(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	err = m.db.SetProfile(&profile)
+	err = m.db.SetProfileContext(r.Context(), &profile)
+	if r.Context().Err() != nil {
+		// the client went away
+		return
+	}
 	if err != nil {
 		panic(err)
 	}
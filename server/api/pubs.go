@@ -0,0 +1,64 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/awans/mark/app"
+	"github.com/awans/mark/feed"
+)
+
+// Pubs is an admin resource for the pubs this node syncs with
+type Pubs struct {
+	db *app.DB
+}
+
+// NewPubs builds a pubs resource
+func NewPubs(db *app.DB) *Pubs {
+	return &Pubs{db: db}
+}
+
+type pubHealth struct {
+	URL         string         `json:"url"`
+	Score       float64        `json:"score"`
+	SuccessRate float64        `json:"success_rate"`
+	Failures    int            `json:"failures"`
+	LastChecked int64          `json:"last_checked"`
+	LastUpdated int64          `json:"last_updated"`
+	Health      feed.PubHealth `json:"health"`
+	Info        *feed.Info     `json:"info,omitempty"`
+}
+
+// GetPubHealth returns each pub's health, best first
+func (p *Pubs) GetPubHealth(w http.ResponseWriter, r *http.Request) {
+	pubs, err := p.db.GetPubs()
+	if err != nil {
+		panic(err)
+	}
+	self, err := p.db.GetSelf()
+	if err != nil {
+		panic(err)
+	}
+
+	out := make([]pubHealth, 0, len(pubs))
+	for _, pub := range pubs {
+		if self != nil && pub.URL == self.URL {
+			continue
+		}
+		out = append(out, pubHealth{
+			URL:         pub.URL,
+			Score:       pub.Score(),
+			SuccessRate: pub.Health.SuccessRate(),
+			Failures:    pub.Failures,
+			LastChecked: pub.LastChecked,
+			LastUpdated: pub.LastUpdated,
+			Health:      pub.Health,
+			Info:        pub.Info,
+		})
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Score > out[j].Score })
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(out)
+}
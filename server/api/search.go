@@ -0,0 +1,63 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/awans/mark/app"
+)
+
+// Search finds bookmarks by their text
+type Search struct {
+	db *app.DB
+}
+
+// NewSearch builds a search resource
+func NewSearch(db *app.DB) *Search {
+	return &Search{db: db}
+}
+
+// GetSearch returns bookmarks matching q, best match first
+func (s *Search) GetSearch(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+	count := -1
+	if countS := r.URL.Query().Get("count"); countS != "" {
+		c, err := strconv.Atoi(countS)
+		if err != nil {
+			http.Error(w, "Bad count: "+countS, http.StatusBadRequest)
+			return
+		}
+		count = c
+	}
+	offset := -1
+	if offsetS := r.URL.Query().Get("offset"); offsetS != "" {
+		o, err := strconv.Atoi(offsetS)
+		if err != nil {
+			http.Error(w, "Bad offset: "+offsetS, http.StatusBadRequest)
+			return
+		}
+		offset = o
+	}
+
+	bookmarks, err := s.db.SearchContext(r.Context(), q, count, offset)
+	if r.Context().Err() != nil {
+		// the client went away
+		return
+	}
+	if err != nil {
+		panic(err)
+	}
+
+	sbs := make([]streamBookmark, 0)
+	for _, b := range bookmarks {
+		p, err := s.db.GetProfile(b.FeedID)
+		if err != nil {
+			panic(err)
+		}
+		sbs = append(sbs, streamBookmark{Bookmark: b, Profile: p})
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(sbs)
+}
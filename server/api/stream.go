@@ -43,7 +43,11 @@ func (s *Stream) GetStream(w http.ResponseWriter, r *http.Request) {
 	} else {
 		feedID = feedIDParam[0]
 	}
-	bookmarks, err := s.db.GetStream(count, offset, feedID)
+	bookmarks, err := s.db.GetStreamContext(r.Context(), count, offset, feedID)
+	if r.Context().Err() != nil {
+		// the client went away
+		return
+	}
 	if err != nil {
 		panic(err)
 	}
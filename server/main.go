@@ -46,6 +46,8 @@ func New(db *app.DB) (http.Handler, *sandstorm.SessionBus) {
 	apiRouter := r.PathPrefix("/api").Subrouter()
 	s := api.NewStream(db)
 	apiRouter.HandleFunc("/stream", s.GetStream).Methods("GET")
+	search := api.NewSearch(db)
+	apiRouter.HandleFunc("/search", search.GetSearch).Methods("GET")
 	b := api.NewBookmark(db)
 	apiRouter.HandleFunc("/bookmark", b.AddBookmark).Methods("POST")
 	apiRouter.HandleFunc("/bookmark/{id}", b.RemoveBookmark).Methods("DELETE")
@@ -54,6 +56,19 @@ func New(db *app.DB) (http.Handler, *sandstorm.SessionBus) {
 	me := api.NewMe(db)
 	apiRouter.HandleFunc("/profile", me.GetProfile).Methods("GET")
 	apiRouter.HandleFunc("/profile", me.PutProfile).Methods("PUT")
+	follows := api.NewFollows(db)
+	apiRouter.HandleFunc("/follows", follows.GetFollows).Methods("GET")
+	apiRouter.HandleFunc("/follows", follows.AddFollow).Methods("POST")
+	apiRouter.HandleFunc("/follows/{target}", follows.RemoveFollow).Methods("DELETE")
+	blocks := api.NewBlocks(db)
+	apiRouter.HandleFunc("/blocks", blocks.GetBlocked).Methods("GET")
+	apiRouter.HandleFunc("/blocks", blocks.AddBlock).Methods("POST")
+	apiRouter.HandleFunc("/blocks/{feed}", blocks.RemoveBlock).Methods("DELETE")
+	apiRouter.HandleFunc("/mutes", blocks.GetMuted).Methods("GET")
+	apiRouter.HandleFunc("/mutes", blocks.AddMute).Methods("POST")
+	apiRouter.HandleFunc("/mutes/{feed}", blocks.RemoveMute).Methods("DELETE")
+	pubs := api.NewPubs(db)
+	apiRouter.HandleFunc("/admin/pubs", pubs.GetPubHealth).Methods("GET")
 	self := api.NewSelf(db)
 	apiRouter.HandleFunc("/self", self.GetSelf).Methods("GET")
 	apiRouter.HandleFunc("/self", self.PutSelf).Methods("PUT")
@@ -66,6 +81,9 @@ func New(db *app.DB) (http.Handler, *sandstorm.SessionBus) {
 	syncRouter.HandleFunc("/pubs", p.GetPubs).Methods("GET")
 	h := sync.NewHeadsResource(db)
 	syncRouter.HandleFunc("/heads", h.GetHeads).Methods("GET")
+	syncRouter.HandleFunc("/digest", h.GetDigest).Methods("GET")
+	i := sync.NewInfoResource(db)
+	syncRouter.HandleFunc("/info", i.GetInfo).Methods("GET")
 	f := sync.NewFeedResource(db)
 	syncRouter.HandleFunc("/feed/{id}", f.GetFeed).Methods("GET")
 
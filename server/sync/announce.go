@@ -2,43 +2,106 @@ package sync
 
 import (
 	"fmt"
+	"net"
 	"net/http"
 
 	"github.com/awans/mark/app"
 	"github.com/awans/mark/feed"
 )
 
+// Announcements allowed from each feed at each address: a burst, then one every 5 seconds
+const (
+	announceRate  = 0.2
+	announceBurst = 10
+)
+
+// Announcements waiting to be received, and how many are received at once
+// Any more than the queue holds are turned away until it drains
+const (
+	announceQueue   = 64
+	announceWorkers = 2
+)
+
 // AnnounceResource accepts announcements
 type AnnounceResource struct {
-	db *app.DB
+	db      *app.DB
+	limiter *limiter
+	queue   chan *feed.Announcement
 }
 
-// NewAnnounceResource constructs a AnnounceResource
+// NewAnnounceResource constructs a AnnounceResource, and starts its workers
 func NewAnnounceResource(db *app.DB) *AnnounceResource {
-	return &AnnounceResource{db: db}
+	a := &AnnounceResource{
+		db:      db,
+		limiter: newLimiter(announceRate, announceBurst),
+		queue:   make(chan *feed.Announcement, announceQueue),
+	}
+	for i := 0; i < announceWorkers; i++ {
+		go a.receive()
+	}
+	return a
 }
 
-// GetAnnouncement accepts updates to pubs
-func (a *AnnounceResource) GetAnnouncement(w http.ResponseWriter, r *http.Request) {
-	announcedURL := r.URL.Query().Get("url")
-	fmt.Printf("Got announcement from: %s\n", announcedURL)
-
-	go func() {
-		p := feed.Pub{URL: announcedURL}
-		a.db.PutPub(&p)
-		feeds, err := a.db.GetFeeds()
-		newPubs, newFeeds, err := feed.Sync([]feed.Pub{p}, feeds)
+// receive brings the db up to date with queued announcements, one at a time
+func (a *AnnounceResource) receive() {
+	for announcement := range a.queue {
+		err := a.db.ReceiveAnnouncement(announcement)
 		if err != nil {
-			panic(err)
-		}
-		for _, f := range newFeeds {
-			a.db.PutFeed(f)
+			fmt.Println(err)
 		}
-		for _, p := range newPubs {
-			a.db.PutPub(&p)
-		}
-	}()
+	}
+}
+
+// enqueue hands an announcement to the workers, unless the queue is full
+func (a *AnnounceResource) enqueue(announcement *feed.Announcement) bool {
+	select {
+	case a.queue <- announcement:
+		return true
+	default:
+		return false
+	}
+}
+
+// GetAnnouncement accepts updates to pubs
+// Only signed announcements from known feeds, for the pub the feed declared, are accepted
+// Checking the signature is expensive, so the limit applies to the feed the announcement
+// claims to be from, at the address it came from, before anything is verified
+func (a *AnnounceResource) GetAnnouncement(w http.ResponseWriter, r *http.Request) {
+	signed := r.URL.Query().Get("announcement")
+	if signed == "" {
+		http.Error(w, "Unsigned announcements aren't accepted", http.StatusBadRequest)
+		return
+	}
+	unverified, err := feed.OpenAnnouncement(signed)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !a.limiter.allow(remoteHost(r) + " " + unverified.From) {
+		http.Error(w, "Too many announcements", http.StatusTooManyRequests)
+		return
+	}
+	announcement, err := a.db.VerifyAnnouncement(signed)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	fmt.Printf("Got announcement from: %s at %s\n", announcement.From, announcement.Pub.URL)
+
+	if !a.enqueue(announcement) {
+		http.Error(w, "Too many announcements waiting", http.StatusServiceUnavailable)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.WriteHeader(http.StatusAccepted)
 }
+
+// remoteHost is the address a request came from, without its port
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
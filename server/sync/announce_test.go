@@ -0,0 +1,68 @@
+package sync
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/awans/mark/feed"
+)
+
+func newTestResource(t *testing.T) (*AnnounceResource, func()) {
+	db, _, cleanup := newTestUser(t)
+	return NewAnnounceResource(db), cleanup
+}
+
+// forged is an announcement from "from" with a signature that's never checked
+// against anything real, since the feed isn't known
+func forged(from string) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"from":"` + from + `"}`))
+	return "e30." + payload + ".c2ln"
+}
+
+func announce(a *AnnounceResource, remote string, signed string) int {
+	r := httptest.NewRequest("GET", "/sync/announce?announcement="+url.QueryEscape(signed), nil)
+	r.RemoteAddr = remote
+	w := httptest.NewRecorder()
+	a.GetAnnouncement(w, r)
+	return w.Code
+}
+
+func TestAnnouncementsAreLimitedBeforeVerifying(t *testing.T) {
+	a, cleanup := newTestResource(t)
+	defer cleanup()
+
+	for i := 0; i < announceBurst; i++ {
+		if code := announce(a, "10.0.0.1:1000", forged("someone")); code != http.StatusForbidden {
+			t.Fatalf("announcement %d: got %d, want %d", i, code, http.StatusForbidden)
+		}
+	}
+	// another port is the same address
+	if code := announce(a, "10.0.0.1:2000", forged("someone")); code != http.StatusTooManyRequests {
+		t.Errorf("past the burst: got %d, want %d", code, http.StatusTooManyRequests)
+	}
+	if code := announce(a, "10.0.0.2:1000", forged("someone")); code != http.StatusForbidden {
+		t.Errorf("another address: got %d, want %d", code, http.StatusForbidden)
+	}
+	if code := announce(a, "10.0.0.1:1000", forged("someone else")); code != http.StatusForbidden {
+		t.Errorf("another feed: got %d, want %d", code, http.StatusForbidden)
+	}
+	if code := announce(a, "10.0.0.1:1000", "garbage"); code != http.StatusBadRequest {
+		t.Errorf("malformed: got %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestAnnounceQueueIsBounded(t *testing.T) {
+	// no workers, so nothing drains the queue
+	a := &AnnounceResource{queue: make(chan *feed.Announcement, announceQueue)}
+	for i := 0; i < announceQueue; i++ {
+		if !a.enqueue(&feed.Announcement{}) {
+			t.Fatalf("announcement %d was turned away", i)
+		}
+	}
+	if a.enqueue(&feed.Announcement{}) {
+		t.Error("a full queue took another announcement")
+	}
+}
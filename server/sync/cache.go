@@ -0,0 +1,103 @@
+package sync
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/awans/mark/app"
+)
+
+// maxCachedResponses bounds a responseCache, since heads can be asked for by any set of buckets
+const maxCachedResponses = 1024
+
+// cachedResponse is a response and its validators, as of a version of the db
+type cachedResponse struct {
+	version  uint64
+	etag     string
+	modified time.Time
+	body     []byte // nil if it was too big to keep
+}
+
+// responseCache keeps responses until the feeds or pubs change,
+// and remembers when each one's tag last changed for Last-Modified
+type responseCache struct {
+	db      *app.DB
+	mu      sync.Mutex
+	entries map[string]*cachedResponse
+}
+
+func newResponseCache(db *app.DB) *responseCache {
+	return &responseCache{db: db, entries: make(map[string]*cachedResponse)}
+}
+
+// build makes a response's tag and body
+type build func() (etag string, body []byte, err error)
+
+// serve writes the response for key, building it only if the db changed since it was cached
+// keep says whether the body is small enough to keep
+// A request whose If-None-Match or If-Modified-Since still holds gets a 304
+func (c *responseCache) serve(w http.ResponseWriter, r *http.Request, key string, keep bool, b build) error {
+	version := c.db.Version()
+	c.mu.Lock()
+	cr := c.entries[key]
+	c.mu.Unlock()
+
+	var body []byte
+	if cr == nil || cr.version != version || (cr.body == nil && !notModified(r, cr)) {
+		etag, built, err := b()
+		if err != nil {
+			return err
+		}
+		body = built
+		next := &cachedResponse{version: version, etag: etag, modified: time.Now()}
+		if cr != nil && cr.etag == etag {
+			next.modified = cr.modified
+		}
+		if keep {
+			next.body = body
+		}
+		cr = next
+		c.mu.Lock()
+		if _, ok := c.entries[key]; !ok && len(c.entries) >= maxCachedResponses {
+			for k := range c.entries {
+				delete(c.entries, k)
+				break
+			}
+		}
+		c.entries[key] = cr
+		c.mu.Unlock()
+	} else if cr.body != nil {
+		body = cr.body
+	}
+
+	w.Header().Set("ETag", cr.etag)
+	w.Header().Set("Last-Modified", cr.modified.UTC().Format(http.TimeFormat))
+	if notModified(r, cr) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Write(body)
+	return nil
+}
+
+// notModified checks a request's validators against a response
+// If-None-Match wins when both are sent
+func notModified(r *http.Request, cr *cachedResponse) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, tag := range strings.Split(match, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == cr.etag {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	return !cr.modified.Truncate(time.Second).After(since)
+}
@@ -5,32 +5,42 @@ import (
 	"net/http"
 
 	"github.com/awans/mark/app"
+	"github.com/awans/mark/feed"
 	"github.com/gorilla/mux"
 )
 
 // FeedResource exposes a feed
 type FeedResource struct {
-	db *app.DB
+	db    *app.DB
+	cache *responseCache
 }
 
 // NewHeadsResource constructs a HeadsResource
 func NewFeedResource(db *app.DB) *FeedResource {
-	return &FeedResource{db: db}
+	return &FeedResource{db: db, cache: newResponseCache(db)}
 }
 
 // GetHeads returns head of each feed
 func (f *FeedResource) GetFeed(w http.ResponseWriter, r *http.Request) {
 	id := mux.Vars(r)["id"]
-	feed, err := f.db.GetFeed(id)
+	blocked, err := f.db.IsBlocked(id)
 	if err != nil {
 		panic(err)
 	}
-
-	bytes, err := json.Marshal(feed)
+	if blocked {
+		http.NotFound(w, r)
+		return
+	}
+	// feeds are too big to keep, but their tags are cheap to check
+	err = f.cache.serve(w, r, id, false, func() (string, []byte, error) {
+		sf, err := f.db.GetFeed(id)
+		if err != nil {
+			return "", nil, err
+		}
+		bytes, err := json.Marshal(sf)
+		return feed.FeedETag(sf), bytes, err
+	})
 	if err != nil {
 		panic(err)
 	}
-
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-	w.Write(bytes)
 }
@@ -10,35 +10,85 @@ import (
 
 // HeadsResource exposes the head of each feed
 type HeadsResource struct {
-	db *app.DB
+	db    *app.DB
+	cache *responseCache
 }
 
 // NewHeadsResource constructs a HeadsResource
 func NewHeadsResource(db *app.DB) *HeadsResource {
-	return &HeadsResource{db: db}
+	return &HeadsResource{db: db, cache: newResponseCache(db)}
 }
 
-// GetHeads returns head of each feed
-func (h *HeadsResource) GetHeads(w http.ResponseWriter, r *http.Request) {
+// heads returns the head of each feed we serve
+func (h *HeadsResource) heads() ([]feed.Head, error) {
 	feeds, err := h.db.GetFeeds()
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	blocked, err := h.db.GetBlocked()
+	if err != nil {
+		return nil, err
+	}
+	isBlocked := make(map[string]bool)
+	for _, id := range blocked {
+		isBlocked[id] = true
 	}
 
 	var heads []feed.Head
 	for _, f := range feeds {
 		fp, err := f.Fingerprint()
 		if err != nil {
-			panic(err)
+			return nil, err
+		}
+		if isBlocked[fp] {
+			continue
 		}
 		heads = append(heads, feed.Head{ID: fp, Len: len(f)})
 	}
+	return heads, nil
+}
 
-	bytes, err := json.Marshal(heads)
+// GetHeads returns head of each feed
+// With ?buckets=1,5 it returns only the heads in those digest buckets
+func (h *HeadsResource) GetHeads(w http.ResponseWriter, r *http.Request) {
+	var buckets []int
+	spec := r.URL.Query().Get("buckets")
+	if spec != "" {
+		var err error
+		buckets, err = feed.ParseBuckets(spec)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	err := h.cache.serve(w, r, "heads?"+spec, true, func() (string, []byte, error) {
+		heads, err := h.heads()
+		if err != nil {
+			return "", nil, err
+		}
+		if spec != "" {
+			heads = feed.InBuckets(heads, buckets)
+		}
+		bytes, err := json.Marshal(heads)
+		return feed.HeadsETag(heads), bytes, err
+	})
 	if err != nil {
 		panic(err)
 	}
+}
 
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-	w.Write(bytes)
+// GetDigest returns a digest of the heads, so a peer can ask for just the ones that differ
+func (h *HeadsResource) GetDigest(w http.ResponseWriter, r *http.Request) {
+	err := h.cache.serve(w, r, "digest", true, func() (string, []byte, error) {
+		heads, err := h.heads()
+		if err != nil {
+			return "", nil, err
+		}
+		bytes, err := json.Marshal(feed.Digest(heads))
+		return feed.HeadsETag(heads), bytes, err
+	})
+	if err != nil {
+		panic(err)
+	}
 }
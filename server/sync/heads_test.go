@@ -0,0 +1,126 @@
+package sync
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/awans/mark/app"
+	"github.com/awans/mark/entities"
+	"github.com/awans/mark/feed"
+	"github.com/gorilla/mux"
+)
+
+// testKeyBits keeps key generation quick; test keys only sign test feeds
+const testKeyBits = 1024
+
+// newTestUser makes a user the way mark init does, and a func that removes its store
+func newTestUser(t *testing.T) (*app.DB, string, func()) {
+	dir, err := ioutil.TempDir("", "mark-sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := entities.CreateBackendStore(entities.BoltBackend, dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	cleanup := func() {
+		store.Close()
+		os.RemoveAll(dir)
+	}
+	fail := func(err error) {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, testKeyBits)
+	if err != nil {
+		fail(err)
+	}
+	f, err := feed.New(key)
+	if err != nil {
+		fail(err)
+	}
+	fp, err := f.Fingerprint()
+	if err != nil {
+		fail(err)
+	}
+	e := entities.NewDB(store, fp, key)
+	app.Configure(e)
+	if _, err = e.PutUserFeed(f); err != nil {
+		fail(err)
+	}
+	if err = e.OpenIndexes(); err != nil {
+		fail(err)
+	}
+	return app.NewDB(e), fp, cleanup
+}
+
+func headIDs(t *testing.T, h *HeadsResource) map[string]bool {
+	w := httptest.NewRecorder()
+	h.GetHeads(w, httptest.NewRequest("GET", "/sync/heads", nil))
+	var heads []feed.Head
+	if err := json.Unmarshal(w.Body.Bytes(), &heads); err != nil {
+		t.Fatal(err)
+	}
+	ids := make(map[string]bool)
+	for _, head := range heads {
+		ids[head.ID] = true
+	}
+	return ids
+}
+
+func getFeed(f *FeedResource, id string) int {
+	w := httptest.NewRecorder()
+	r := mux.SetURLVars(httptest.NewRequest("GET", "/sync/feeds/"+id, nil), map[string]string{"id": id})
+	f.GetFeed(w, r)
+	return w.Code
+}
+
+func TestBlockedFeedsArentServed(t *testing.T) {
+	db, fp, done := newTestUser(t)
+	defer done()
+	other, otherFP, doneOther := newTestUser(t)
+	defer doneOther()
+	sf, err := other.GetFeed(otherFP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.PutFeed(sf); err != nil {
+		t.Fatal(err)
+	}
+	h, f := NewHeadsResource(db), NewFeedResource(db)
+	if !headIDs(t, h)[otherFP] || getFeed(f, otherFP) != http.StatusOK {
+		t.Fatal("the other feed isn't served")
+	}
+
+	// with no self pub the announcement fails, but the block is written
+	db.Block(otherFP)
+	// a peer that hasn't heard of the block can still send the feed
+	if err = db.PutFeed(sf); err != nil {
+		t.Fatal(err)
+	}
+	if headIDs(t, h)[otherFP] {
+		t.Error("a blocked feed is in the heads")
+	}
+	if code := getFeed(f, otherFP); code != http.StatusNotFound {
+		t.Errorf("a blocked feed: got %d, want %d", code, http.StatusNotFound)
+	}
+	if !headIDs(t, h)[fp] || getFeed(f, fp) != http.StatusOK {
+		t.Error("the user's own feed isn't served")
+	}
+
+	db.Unblock(otherFP)
+	if !headIDs(t, h)[otherFP] {
+		t.Error("an unblocked feed isn't in the heads")
+	}
+	if code := getFeed(f, otherFP); code != http.StatusOK {
+		t.Errorf("an unblocked feed: got %d, want %d", code, http.StatusOK)
+	}
+}
@@ -0,0 +1,29 @@
+package sync
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/awans/mark/app"
+)
+
+// InfoResource tells other nodes what protocol this node speaks
+type InfoResource struct {
+	db *app.DB
+}
+
+// NewInfoResource constructs an InfoResource
+func NewInfoResource(db *app.DB) *InfoResource {
+	return &InfoResource{db: db}
+}
+
+// GetInfo returns the protocol version, op types, key algorithms and software version
+func (i *InfoResource) GetInfo(w http.ResponseWriter, r *http.Request) {
+	bytes, err := json.Marshal(i.db.Info())
+	if err != nil {
+		panic(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Write(bytes)
+}
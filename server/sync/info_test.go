@@ -0,0 +1,34 @@
+package sync
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/awans/mark/feed"
+)
+
+func TestGetInfo(t *testing.T) {
+	db, _, done := newTestUser(t)
+	defer done()
+
+	w := httptest.NewRecorder()
+	NewInfoResource(db).GetInfo(w, httptest.NewRequest("GET", "/sync/info", nil))
+	if ct := w.Header().Get("Content-Type"); ct != "application/json; charset=UTF-8" {
+		t.Errorf("content type is %q", ct)
+	}
+	var got feed.Info
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := db.Info(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got.Protocol != feed.ProtocolVersion || !got.Supports(feed.CapBlocking) || !got.Understands("eav") {
+		t.Errorf("got %+v", got)
+	}
+	if got.Checked != 0 {
+		t.Error("a node's own info says when it was checked")
+	}
+}
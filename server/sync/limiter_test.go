@@ -0,0 +1,75 @@
+package sync
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	type step struct {
+		key string
+		// wait is how long to pretend passed since key's last request
+		wait time.Duration
+		want bool
+	}
+	cases := []struct {
+		name  string
+		rate  float64
+		burst int
+		steps []step
+	}{
+		{
+			name: "burst then refill",
+			rate: 1, burst: 2,
+			steps: []step{{"a", 0, true}, {"a", 0, true}, {"a", 0, false}, {"a", time.Second, true}, {"a", 0, false}},
+		},
+		{
+			name: "keys have their own buckets",
+			rate: 1, burst: 1,
+			steps: []step{{"a", 0, true}, {"a", 0, false}, {"b", 0, true}, {"b", 0, false}},
+		},
+		{
+			name: "refill stops at the burst",
+			rate: 1, burst: 2,
+			steps: []step{{"a", 0, true}, {"a", 0, true}, {"a", time.Minute, true}, {"a", 0, true}, {"a", 0, false}},
+		},
+		{
+			name: "partial tokens add up",
+			rate: 0.5, burst: 1,
+			steps: []step{{"a", 0, true}, {"a", time.Second, false}, {"a", time.Second, true}},
+		},
+		{
+			name: "a refused request doesn't cost a token",
+			rate: 1, burst: 1,
+			steps: []step{{"a", 0, true}, {"a", 0, false}, {"a", 0, false}, {"a", time.Second, true}},
+		},
+	}
+
+	for _, c := range cases {
+		l := newLimiter(c.rate, c.burst)
+		for i, s := range c.steps {
+			if b, ok := l.buckets[s.key]; ok {
+				b.last = b.last.Add(-s.wait)
+			}
+			if got := l.allow(s.key); got != s.want {
+				t.Errorf("%s: step %d: got %v, want %v", c.name, i, got, s.want)
+			}
+		}
+	}
+}
+
+func TestLimiterForgetsFullBuckets(t *testing.T) {
+	l := newLimiter(1, 1)
+	l.allow("spent")
+	for i := 0; len(l.buckets) < maxLimiterKeys; i++ {
+		l.buckets[fmt.Sprintf("full %d", i)] = &bucket{tokens: 1, last: time.Now()}
+	}
+	l.allow("new")
+	if len(l.buckets) != 2 {
+		t.Errorf("kept %d buckets, want the spent one and the new one", len(l.buckets))
+	}
+	if l.allow("spent") {
+		t.Error("the spent bucket was forgotten")
+	}
+}
@@ -5,30 +5,32 @@ import (
 	"net/http"
 
 	"github.com/awans/mark/app"
+	"github.com/awans/mark/feed"
 )
 
 // Pub exposes the pubs this node knows about,
 // and lets other pubs register
 type PubsResource struct {
-	db *app.DB
+	db    *app.DB
+	cache *responseCache
 }
 
 // NewPubsResource builds a debug
 func NewPubsResource(db *app.DB) *PubsResource {
-	return &PubsResource{db: db}
+	return &PubsResource{db: db, cache: newResponseCache(db)}
 }
 
 // GetPubs returns the current user's feed
 func (p *PubsResource) GetPubs(w http.ResponseWriter, r *http.Request) {
-	pubs, err := p.db.GetPubs()
+	err := p.cache.serve(w, r, "pubs", true, func() (string, []byte, error) {
+		pubs, err := p.db.GetPubs()
+		if err != nil {
+			return "", nil, err
+		}
+		bytes, err := json.Marshal(feed.ListPubs(pubs))
+		return feed.ETag(bytes), bytes, err
+	})
 	if err != nil {
 		panic(err)
 	}
-	bytes, err := json.Marshal(pubs)
-	if err != nil {
-		panic(err)
-	}
-
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-	w.Write(bytes)
 }